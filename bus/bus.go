@@ -0,0 +1,116 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Transports
+const (
+	TransportZmq  = "zmq"
+	TransportMqtt = "mqtt"
+	TransportNats = "nats"
+)
+
+// InterrogationCommand is the general interrogation (GI) command of RTDB
+const InterrogationCommand = "{\"dest\": \"rtdb\", \"cmd\": \"gi\"}"
+
+const DefaultGiTimeout = 2 * time.Second
+const DefaultGiRetries = 5
+const DefaultReconnectBackoffMin = 100 * time.Millisecond
+const DefaultReconnectBackoffMax = 30 * time.Second
+
+var ErrInterrogationTimeout = errors.New("bus: general interrogation was not confirmed")
+var ErrClosed = errors.New("bus: transport is closed")
+
+// State of a connection reported to the state handler
+type State int
+
+const (
+	StateConnected State = iota
+	StateReconnecting
+	StateFailed
+)
+
+func (state State) String() string {
+	switch state {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("unknown (%d)", int(state))
+}
+
+// StateHandler is called on the state changes of the connection to the endpoint and must not block
+type StateHandler func(endpoint string, isSubscriber bool, state State, err error)
+
+// ReceiveQueueLength is the number of the received messages waiting for Run, the messages over it are dropped
+// by the transports receiving in the callbacks of the client (MQTT, NATS)
+const ReceiveQueueLength = 1024
+
+// DroppedCounter is implemented by the transports dropping the received messages when the service does not keep up
+type DroppedCounter interface {
+	Dropped() uint64
+}
+
+// Transport of the RTDB messages
+type Transport interface {
+	// Subscribe sets the handler of the messages with the topics, nil topics subscribe to all messages of the bus
+	Subscribe(topics []string, handler func([]string)) error
+	// SetTopics replaces the topics of the subscription
+	SetTopics(topics []string)
+	// Publish sends data with the topic, the default topic of the bus if empty
+	Publish(topic string, data []byte) error
+	// Interrogate sends GI to RTDB and waits for the confirmation if the bus supports it
+	Interrogate() error
+	SetStateHandler(handler StateHandler)
+	// Run calls the handler of the received messages until the context is cancelled or a fatal error
+	Run(ctx context.Context) error
+	// Close delivers the pending messages and closes the connections. Must be called after Run has returned
+	Close() error
+}
+
+// Options of the transport
+type Options struct {
+	Transport           string
+	Url                 string // Broker of MQTT and NATS
+	ClientId            string
+	UserName            string
+	Password            string
+	Input               string // RTDB input: ZMQ endpoint, MQTT topic or NATS subject to publish to
+	Output              string // RTDB output: ZMQ endpoint, MQTT topic or NATS subject to subscribe to
+	GiEndpoint          string // ZMQ REQ/REP endpoint, MQTT topic or NATS request subject of GI, Input if empty
+	GiTimeout           time.Duration
+	GiRetries           int
+	ReconnectRetries    int // 0 - unlimited
+	ReconnectBackoffMax time.Duration
+}
+
+// New creates the transport selected in the options, ZMQ by default
+func New(options Options) (Transport, error) {
+	if options.GiTimeout <= 0 {
+		options.GiTimeout = DefaultGiTimeout
+	}
+	if options.GiRetries <= 0 {
+		options.GiRetries = DefaultGiRetries
+	}
+	if options.ReconnectBackoffMax <= 0 {
+		options.ReconnectBackoffMax = DefaultReconnectBackoffMax
+	}
+
+	switch options.Transport {
+	case "", TransportZmq:
+		return NewZmqTransport(options)
+	case TransportMqtt:
+		return NewMqttTransport(options)
+	case TransportNats:
+		return NewNatsTransport(options)
+	}
+
+	return nil, errors.New(fmt.Sprintf("bus: unknown transport %s", options.Transport))
+}
@@ -0,0 +1,129 @@
+package bus
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Message published to the memory transport
+type Message struct {
+	Topic string
+	Data  []byte
+}
+
+// MemoryTransport is the in-process transport for the scenarios: the messages are injected by Inject
+// and the published ones are read from Published
+type MemoryTransport struct {
+	sync.Mutex
+	handler        func([]string)
+	topics         []string
+	stateHandler   StateHandler
+	messages       chan []string
+	published      chan Message
+	interrogations atomic.Int64
+	isClosed       atomic.Bool
+	OnInterrogate  func() // Called on GI, e.g. to inject the snapshot of the points
+}
+
+func NewMemoryTransport(queueLength int) *MemoryTransport {
+	return &MemoryTransport{
+		messages:  make(chan []string, queueLength),
+		published: make(chan Message, queueLength),
+	}
+}
+
+// Inject the message frames as if they were received from RTDB
+func (t *MemoryTransport) Inject(frames ...string) {
+	t.messages <- frames
+}
+
+// Published returns the messages published by the service. The channel is closed by Close
+func (t *MemoryTransport) Published() <-chan Message {
+	return t.published
+}
+
+// Interrogations returns the number of GI sent by the service
+func (t *MemoryTransport) Interrogations() int {
+	return int(t.interrogations.Load())
+}
+
+// Topics returns the current subscription
+func (t *MemoryTransport) Topics() []string {
+	t.Lock()
+	defer t.Unlock()
+	return t.topics
+}
+
+// SetState reports the state change to the service as a real transport does
+func (t *MemoryTransport) SetState(state State, err error) {
+	t.Lock()
+	handler := t.stateHandler
+	t.Unlock()
+
+	if handler != nil {
+		handler("memory", true, state, err)
+	}
+}
+
+func (t *MemoryTransport) Subscribe(topics []string, handler func([]string)) error {
+	t.Lock()
+	defer t.Unlock()
+
+	t.topics = topics
+	t.handler = handler
+	return nil
+}
+
+func (t *MemoryTransport) SetTopics(topics []string) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.topics = topics
+}
+
+func (t *MemoryTransport) Publish(topic string, data []byte) error {
+	if t.isClosed.Load() {
+		return ErrClosed
+	}
+	t.published <- Message{Topic: topic, Data: data}
+	return nil
+}
+
+func (t *MemoryTransport) Interrogate() error {
+	t.interrogations.Add(1)
+	if t.OnInterrogate != nil {
+		t.OnInterrogate()
+	}
+	return nil
+}
+
+func (t *MemoryTransport) SetStateHandler(handler StateHandler) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.stateHandler = handler
+}
+
+func (t *MemoryTransport) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case msg := <-t.messages:
+			t.Lock()
+			handler := t.handler
+			t.Unlock()
+			if handler != nil {
+				handler(msg)
+			}
+		}
+	}
+}
+
+func (t *MemoryTransport) Close() error {
+	if t.isClosed.CompareAndSwap(false, true) {
+		close(t.published)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const MqttQos = 1
+
+var ErrMqttTimeout = errors.New("mqtt: request was not acknowledged")
+
+// MqttTransport is the transport over MQTT broker. The client reconnects and resubscribes automatically
+type MqttTransport struct {
+	sync.Mutex
+	client       mqtt.Client
+	options      Options
+	topics       []string
+	handler      func([]string)
+	stateHandler StateHandler
+	messages     chan []string
+	fatal        chan error
+	reconnects   int
+	dropped      atomic.Uint64
+}
+
+func NewMqttTransport(options Options) (*MqttTransport, error) {
+	t := &MqttTransport{
+		options:  options,
+		messages: make(chan []string, ReceiveQueueLength),
+		fatal:    make(chan error, 1),
+	}
+
+	clientOptions := mqtt.NewClientOptions().
+		AddBroker(options.Url).
+		SetClientID(options.ClientId).
+		SetUsername(options.UserName).
+		SetPassword(options.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(DefaultReconnectBackoffMin).
+		SetMaxReconnectInterval(options.ReconnectBackoffMax).
+		SetOnConnectHandler(t.onConnect).
+		SetConnectionLostHandler(t.onConnectionLost).
+		SetReconnectingHandler(t.onReconnecting)
+
+	t.client = mqtt.NewClient(clientOptions)
+
+	// With the connect retry the messages published before the connection are queued
+	t.client.Connect()
+
+	return t, nil
+}
+
+func (t *MqttTransport) onConnect(client mqtt.Client) {
+	t.Lock()
+	t.reconnects = 0
+	topics := t.topics
+	t.Unlock()
+
+	if len(topics) > 0 {
+		if err := t.subscribe(topics); err != nil {
+			t.notifyState(true, StateFailed, err)
+			return
+		}
+	}
+
+	t.notifyState(len(topics) > 0, StateConnected, nil)
+}
+
+func (t *MqttTransport) onConnectionLost(client mqtt.Client, err error) {
+	t.notifyState(true, StateReconnecting, err)
+}
+
+func (t *MqttTransport) onReconnecting(client mqtt.Client, options *mqtt.ClientOptions) {
+	t.Lock()
+	t.reconnects += 1
+	reconnects := t.reconnects
+	t.Unlock()
+
+	if t.options.ReconnectRetries > 0 && reconnects > t.options.ReconnectRetries {
+		err := errors.New(fmt.Sprintf("mqtt: failed to reconnect to %s after %d attempts", t.options.Url, reconnects-1))
+		t.notifyState(true, StateFailed, err)
+		select {
+		case t.fatal <- err:
+		default:
+		}
+	}
+}
+
+func (t *MqttTransport) notifyState(isSubscriber bool, state State, err error) {
+	t.Lock()
+	handler := t.stateHandler
+	t.Unlock()
+
+	if handler != nil {
+		handler(t.options.Url, isSubscriber, state, err)
+	}
+}
+
+// receive is called by the router of the client and must not block it: the message is dropped if the queue is full
+func (t *MqttTransport) receive(client mqtt.Client, msg mqtt.Message) {
+	select {
+	case t.messages <- []string{string(msg.Payload())}:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of the received messages dropped because the queue was full
+func (t *MqttTransport) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+func (t *MqttTransport) subscribe(topics []string) error {
+	filters := make(map[string]byte, len(topics))
+	for _, topic := range topics {
+		filters[topic] = MqttQos
+	}
+	return t.wait(t.client.SubscribeMultiple(filters, t.receive))
+}
+
+func (t *MqttTransport) wait(token mqtt.Token) error {
+	if !token.WaitTimeout(t.options.GiTimeout) {
+		return ErrMqttTimeout
+	}
+	return token.Error()
+}
+
+// Subscribe to the topic filters (with + and # wildcards), nil topics subscribe to the output topic
+func (t *MqttTransport) Subscribe(topics []string, handler func([]string)) error {
+	if topics == nil {
+		topics = []string{t.options.Output}
+	}
+
+	t.Lock()
+	t.topics = topics
+	t.handler = handler
+	t.Unlock()
+
+	if t.client.IsConnectionOpen() && len(topics) > 0 {
+		return t.subscribe(topics)
+	}
+	return nil
+}
+
+func (t *MqttTransport) SetTopics(topics []string) {
+	t.Lock()
+	oldTopics := t.topics
+	t.topics = topics
+	t.Unlock()
+
+	if !t.client.IsConnectionOpen() {
+		return
+	}
+
+	newTopics := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		newTopics[topic] = true
+	}
+
+	removed := make([]string, 0)
+	for _, topic := range oldTopics {
+		if !newTopics[topic] {
+			removed = append(removed, topic)
+		}
+	}
+
+	if len(removed) > 0 {
+		t.client.Unsubscribe(removed...)
+	}
+
+	if len(topics) > 0 {
+		_ = t.subscribe(topics)
+	}
+}
+
+// Publish waits for the acknowledgement of the broker up to the GI timeout
+func (t *MqttTransport) Publish(topic string, data []byte) error {
+	if topic == "" {
+		topic = t.options.Input
+	}
+	return t.wait(t.client.Publish(topic, MqttQos, false, data))
+}
+
+// Interrogate publishes GI and waits for the acknowledgement of the broker
+func (t *MqttTransport) Interrogate() error {
+	topic := t.options.GiEndpoint
+	if topic == "" {
+		topic = t.options.Input
+	}
+
+	for attempt := 0; attempt <= t.options.GiRetries; attempt++ {
+		err := t.wait(t.client.Publish(topic, MqttQos, false, InterrogationCommand))
+		if !errors.Is(err, ErrMqttTimeout) {
+			return err
+		}
+	}
+	return ErrInterrogationTimeout
+}
+
+func (t *MqttTransport) SetStateHandler(handler StateHandler) {
+	t.Lock()
+	t.stateHandler = handler
+	t.Unlock()
+}
+
+func (t *MqttTransport) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-t.fatal:
+			return err
+		case msg := <-t.messages:
+			t.Lock()
+			handler := t.handler
+			t.Unlock()
+			if handler != nil {
+				handler(msg)
+			}
+		}
+	}
+}
+
+// Close waits up to one second for the pending messages and disconnects
+func (t *MqttTransport) Close() error {
+	t.client.Disconnect(uint(time.Second / time.Millisecond))
+	return nil
+}
@@ -0,0 +1,233 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// NatsTransport is the transport over NATS. Subscriptions are restored by the client after the reconnection
+type NatsTransport struct {
+	sync.Mutex
+	conn          *nats.Conn
+	options       Options
+	subscriptions map[string]*nats.Subscription
+	handler       func([]string)
+	stateHandler  StateHandler
+	messages      chan []string
+	fatal         chan error
+	isClosed      atomic.Bool
+	dropped       atomic.Uint64
+}
+
+func NewNatsTransport(options Options) (*NatsTransport, error) {
+	t := &NatsTransport{
+		options:       options,
+		subscriptions: make(map[string]*nats.Subscription),
+		messages:      make(chan []string, ReceiveQueueLength),
+		fatal:         make(chan error, 1),
+	}
+
+	maxReconnects := options.ReconnectRetries
+	if maxReconnects <= 0 {
+		maxReconnects = -1
+	}
+
+	natsOptions := []nats.Option{
+		nats.Name(options.ClientId),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(maxReconnects),
+		nats.ReconnectWait(DefaultReconnectBackoffMin),
+		nats.CustomReconnectDelay(func(attempts int) time.Duration {
+			return backoff(attempts, options.ReconnectBackoffMax)
+		}),
+		nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+			t.notifyState(StateReconnecting, err)
+		}),
+		nats.ConnectHandler(func(conn *nats.Conn) {
+			t.notifyState(StateConnected, nil)
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			t.notifyState(StateConnected, nil)
+		}),
+		nats.ClosedHandler(func(conn *nats.Conn) {
+			if t.isClosed.Load() {
+				return
+			}
+			err := conn.LastError()
+			if err == nil {
+				err = ErrClosed
+			}
+			t.notifyState(StateFailed, err)
+			select {
+			case t.fatal <- errors.New(fmt.Sprintf("nats: connection to %s closed: %v", options.Url, err)):
+			default:
+			}
+		}),
+	}
+
+	if options.UserName != "" {
+		natsOptions = append(natsOptions, nats.UserInfo(options.UserName, options.Password))
+	}
+
+	conn, err := nats.Connect(options.Url, natsOptions...)
+	if err != nil {
+		return nil, err
+	}
+
+	t.conn = conn
+	return t, nil
+}
+
+// backoff doubles the delay from DefaultReconnectBackoffMin up to max
+func backoff(attempts int, max time.Duration) time.Duration {
+	delay := DefaultReconnectBackoffMin
+	for i := 1; i < attempts && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+func (t *NatsTransport) notifyState(state State, err error) {
+	t.Lock()
+	handler := t.stateHandler
+	isSubscriber := len(t.subscriptions) > 0
+	t.Unlock()
+
+	if handler != nil {
+		handler(t.options.Url, isSubscriber, state, err)
+	}
+}
+
+// receive is called by the delivery goroutine of the subscription and must not block it:
+// the message is dropped if the queue is full
+func (t *NatsTransport) receive(msg *nats.Msg) {
+	select {
+	case t.messages <- []string{string(msg.Data)}:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// Dropped returns the number of the received messages dropped because the queue was full
+func (t *NatsTransport) Dropped() uint64 {
+	return t.dropped.Load()
+}
+
+// Subscribe to the subjects (with * and > wildcards), nil topics subscribe to the output subject
+func (t *NatsTransport) Subscribe(topics []string, handler func([]string)) error {
+	if topics == nil {
+		topics = []string{t.options.Output}
+	}
+
+	t.Lock()
+	t.handler = handler
+	t.Unlock()
+
+	return t.setTopics(topics)
+}
+
+func (t *NatsTransport) SetTopics(topics []string) {
+	_ = t.setTopics(topics)
+}
+
+func (t *NatsTransport) setTopics(topics []string) error {
+	t.Lock()
+	defer t.Unlock()
+
+	newTopics := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		newTopics[topic] = true
+	}
+
+	for topic, subscription := range t.subscriptions {
+		if !newTopics[topic] {
+			_ = subscription.Unsubscribe()
+			delete(t.subscriptions, topic)
+		}
+	}
+
+	for topic := range newTopics {
+		if _, exists := t.subscriptions[topic]; exists {
+			continue
+		}
+		subscription, err := t.conn.Subscribe(topic, t.receive)
+		if err != nil {
+			return err
+		}
+		t.subscriptions[topic] = subscription
+	}
+
+	return nil
+}
+
+func (t *NatsTransport) Publish(topic string, data []byte) error {
+	if topic == "" {
+		topic = t.options.Input
+	}
+	return t.conn.Publish(topic, data)
+}
+
+// Interrogate sends the GI request to RTDB and waits for the reply if the GI subject is set,
+// otherwise publishes GI to the input subject
+func (t *NatsTransport) Interrogate() error {
+	if t.options.GiEndpoint == "" {
+		if err := t.conn.Publish(t.options.Input, []byte(InterrogationCommand)); err != nil {
+			return err
+		}
+		return t.conn.FlushTimeout(t.options.GiTimeout)
+	}
+
+	for attempt := 0; attempt <= t.options.GiRetries; attempt++ {
+		_, err := t.conn.Request(t.options.GiEndpoint, []byte(InterrogationCommand), t.options.GiTimeout)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, nats.ErrTimeout) && !errors.Is(err, nats.ErrNoResponders) {
+			return err
+		}
+		if errors.Is(err, nats.ErrNoResponders) {
+			time.Sleep(t.options.GiTimeout)
+		}
+	}
+	return ErrInterrogationTimeout
+}
+
+func (t *NatsTransport) SetStateHandler(handler StateHandler) {
+	t.Lock()
+	t.stateHandler = handler
+	t.Unlock()
+}
+
+func (t *NatsTransport) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-t.fatal:
+			return err
+		case msg := <-t.messages:
+			t.Lock()
+			handler := t.handler
+			t.Unlock()
+			if handler != nil {
+				handler(msg)
+			}
+		}
+	}
+}
+
+// Close sends the pending messages and closes the connection
+func (t *NatsTransport) Close() error {
+	err := t.conn.FlushTimeout(time.Second)
+	t.isClosed.Store(true)
+	t.conn.Close()
+	return err
+}
@@ -0,0 +1,102 @@
+package bus
+
+import (
+	"context"
+	"grid_losses/zmq_bus"
+)
+
+// ZmqTransport is the transport over ZmqBus. Topics are sent in the first frame of the message
+type ZmqTransport struct {
+	bus           *zmq_bus.ZmqBus
+	options       Options
+	subscriberIdx int
+	publisherIdx  int
+}
+
+func NewZmqTransport(options Options) (*ZmqTransport, error) {
+	zmqBus, err := zmq_bus.New(1, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	zmqBus.GiTimeout = options.GiTimeout
+	zmqBus.GiRetries = options.GiRetries
+	zmqBus.ReconnectRetries = options.ReconnectRetries
+	zmqBus.ReconnectBackoffMax = options.ReconnectBackoffMax
+
+	t := &ZmqTransport{bus: zmqBus, options: options, subscriberIdx: -1}
+
+	if t.publisherIdx, err = zmqBus.AddPublisher(options.Input); err != nil {
+		_ = zmqBus.Close()
+		return nil, err
+	}
+
+	return t, nil
+}
+
+func (t *ZmqTransport) Subscribe(topics []string, handler func([]string)) error {
+	var err error
+
+	if topics != nil {
+		t.subscriberIdx, err = t.bus.AddSubscriberWithTopics(t.options.Output, topics)
+	} else {
+		t.subscriberIdx, err = t.bus.AddSubscriber(t.options.Output)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	t.bus.SetReceiveHandler(t.subscriberIdx, handler)
+	return nil
+}
+
+func (t *ZmqTransport) SetTopics(topics []string) {
+	if t.subscriberIdx >= 0 {
+		t.bus.SetSubscriberTopics(t.subscriberIdx, topics)
+	}
+}
+
+func (t *ZmqTransport) Publish(topic string, data []byte) error {
+	var err error
+	if topic != "" {
+		_, err = t.bus.SendWithTopic(t.publisherIdx, topic, data)
+	} else {
+		_, err = t.bus.Send(t.publisherIdx, data)
+	}
+	return err
+}
+
+// Interrogate requests GI from the RTDB REQ/REP endpoint or publishes it to the RTDB input
+func (t *ZmqTransport) Interrogate() error {
+	if t.options.GiEndpoint != "" {
+		_, err := t.bus.RequestInterrogationFromRtdb(t.options.GiEndpoint)
+		return err
+	}
+	_, err := t.bus.SendInterrogationCommandToRtdb(t.options.Input)
+	return err
+}
+
+func (t *ZmqTransport) SetStateHandler(handler StateHandler) {
+	t.bus.SetStateHandler(func(endpoint zmq_bus.EndpointStruct, state zmq_bus.BusState, err error) {
+		handler(endpoint.Endpoint, endpoint.IsSubscriber, stateFromZmq(state), err)
+	})
+}
+
+func (t *ZmqTransport) Run(ctx context.Context) error {
+	return t.bus.WaitingLoopContext(ctx)
+}
+
+func (t *ZmqTransport) Close() error {
+	return t.bus.Close()
+}
+
+func stateFromZmq(state zmq_bus.BusState) State {
+	switch state {
+	case zmq_bus.BusStateReconnecting:
+		return StateReconnecting
+	case zmq_bus.BusStateFailed:
+		return StateFailed
+	}
+	return StateConnected
+}
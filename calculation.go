@@ -0,0 +1,136 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"slices"
+	"sync"
+)
+
+// CalculationStruct shards the branches by feeder across the calculation workers.
+// ReceiveDataWorker waits for the workers, so the model is not changed while they read it
+type CalculationStruct struct {
+	shardFromBranchIdx []int
+	batches            []chan []int
+	done               sync.WaitGroup
+}
+
+// startCalculationWorkers if more than one is configured. Must be called from ReceiveDataWorker
+func (s *ThisService) startCalculationWorkers() {
+	workers := s.config.GridLosses.CalculationWorkers
+	if workers <= 1 {
+		return
+	}
+
+	c := &s.calculation
+	c.batches = make([]chan []int, workers)
+
+	for shard := range c.batches {
+		c.batches[shard] = make(chan []int)
+		go func(batches <-chan []int) {
+			for batch := range batches {
+				for _, idx := range batch {
+					s.CalculateBranchLoss(idx)
+				}
+				c.done.Done()
+			}
+		}(c.batches[shard])
+	}
+
+	s.assignBranchShards()
+}
+
+// stopCalculationWorkers. Must be called from ReceiveDataWorker
+func (s *ThisService) stopCalculationWorkers() {
+	for _, batches := range s.calculation.batches {
+		close(batches)
+	}
+	s.calculation.batches = nil
+}
+
+// assignBranchShards puts all the branches of a feeder to the same worker. Must be called from ReceiveDataWorker
+func (s *ThisService) assignBranchShards() {
+	c := &s.calculation
+	if len(c.batches) == 0 {
+		return
+	}
+
+	shardFromFeeder := make(map[int]int)
+	c.shardFromBranchIdx = make([]int, len(s.branchLosses))
+
+	s.modelLock.RLock()
+	defer s.modelLock.RUnlock()
+
+	for idx := range s.branchLosses {
+		feeder := s.branchFeeder(&s.branchLosses[idx])
+		shard, exists := shardFromFeeder[feeder]
+		if !exists {
+			shard = len(shardFromFeeder) % len(c.batches)
+			shardFromFeeder[feeder] = shard
+		}
+		c.shardFromBranchIdx[idx] = shard
+	}
+
+	llog.Logger.Infof("Losses of %d branches from %d feeders are calculated by %d workers",
+		len(s.branchLosses), len(shardFromFeeder), len(c.batches))
+}
+
+// branchFeeder returns the power node which can energize the equipment of the branch, 0 if not found
+func (s *ThisService) branchFeeder(branch *BranchLossStruct) int {
+	if s.topologyProfile == nil || s.topologyGrid == nil {
+		return 0
+	}
+
+	nodeId := 0
+	for _, node := range s.topologyProfile.Node {
+		if node.EquipmentId == branch.equipmentId {
+			nodeId = node.Id
+			break
+		}
+	}
+	if nodeId == 0 {
+		for _, edge := range s.topologyProfile.Edge {
+			if edge.EquipmentId == branch.equipmentId {
+				nodeId = edge.Terminal1
+				break
+			}
+		}
+	}
+	if nodeId == 0 {
+		return 0
+	}
+
+	poweredBy, err := s.topologyGrid.NodeCanBePoweredBy(nodeId)
+	if err != nil || len(poweredBy) == 0 {
+		return 0
+	}
+	return slices.Min(poweredBy)
+}
+
+// calculateBranches in the calculation workers if they are started. Must be called from ReceiveDataWorker
+func (s *ThisService) calculateBranches(idxArray []int) {
+	c := &s.calculation
+	if len(c.batches) == 0 || len(idxArray) < 2 {
+		for _, idx := range idxArray {
+			s.CalculateBranchLoss(idx)
+		}
+		return
+	}
+
+	batchFromShard := make([][]int, len(c.batches))
+	for _, idx := range idxArray {
+		shard := 0
+		if idx < len(c.shardFromBranchIdx) {
+			shard = c.shardFromBranchIdx[idx]
+		}
+		batchFromShard[shard] = append(batchFromShard[shard], idx)
+	}
+
+	for shard, batch := range batchFromShard {
+		if len(batch) > 0 {
+			c.done.Add(1)
+			c.batches[shard] <- batch
+		}
+	}
+
+	c.done.Wait()
+}
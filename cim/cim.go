@@ -0,0 +1,199 @@
+//
+// The cim package implements a minimal reader of IEC 61970 CIM (CGMES) models in RDF/XML
+//
+
+package cim
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const NamespaceRdf = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// Object is a single CIM resource with its properties.
+// Property keys are local names like "IdentifiedObject.name", references are stored without a leading '#'
+type Object struct {
+	Class    string
+	Id       string
+	Property map[string]string
+}
+
+type Model struct {
+	objects        map[string]*Object
+	order          []string
+	terminalsOfEqp map[string][]*Object
+}
+
+// Get returns the property value by its local name
+func (o *Object) Get(name string) string {
+	return o.Property[name]
+}
+
+// Name of the object (IdentifiedObject.name) or its id if the name is empty
+func (o *Object) Name() string {
+	if name := o.Property["IdentifiedObject.name"]; name != "" {
+		return name
+	}
+	return o.Id
+}
+
+// Parse RDF/XML document. Objects described in several places (rdf:about) are merged
+func Parse(r io.Reader) (*Model, error) {
+	m := &Model{
+		objects:        make(map[string]*Object),
+		terminalsOfEqp: make(map[string][]*Object),
+	}
+
+	decoder := xml.NewDecoder(r)
+
+	var current *Object
+	var property string
+	var text strings.Builder
+	depth := 0
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth += 1
+			switch depth {
+			case 1:
+				if t.Name.Local != "RDF" {
+					return nil, errors.New("cim: root element is not rdf:RDF")
+				}
+			case 2:
+				id := attrRdf(t, "ID")
+				if id == "" {
+					id = attrRdf(t, "about")
+				}
+				id = strings.TrimPrefix(id, "#")
+				if id == "" {
+					current = nil
+					continue
+				}
+				current = m.object(id, t.Name.Local)
+			case 3:
+				property = t.Name.Local
+				text.Reset()
+				if current != nil {
+					if resource := attrRdf(t, "resource"); resource != "" {
+						current.Property[property] = strings.TrimPrefix(resource, "#")
+						property = ""
+					}
+				}
+			}
+		case xml.CharData:
+			if depth == 3 && property != "" {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if depth == 3 && current != nil && property != "" {
+				current.Property[property] = strings.TrimSpace(text.String())
+				property = ""
+			}
+			depth -= 1
+		}
+	}
+
+	if len(m.objects) == 0 {
+		return nil, errors.New("cim: no objects found")
+	}
+
+	for _, id := range m.order {
+		object := m.objects[id]
+		if object.Class != "Terminal" {
+			continue
+		}
+		equipmentId := object.Get("Terminal.ConductingEquipment")
+		if equipmentId != "" {
+			m.terminalsOfEqp[equipmentId] = append(m.terminalsOfEqp[equipmentId], object)
+		}
+	}
+
+	for _, terminals := range m.terminalsOfEqp {
+		sort.SliceStable(terminals, func(i, j int) bool {
+			return sequenceNumber(terminals[i]) < sequenceNumber(terminals[j])
+		})
+	}
+
+	return m, nil
+}
+
+// object returns the existing object by id or creates a new one.
+// The class of the difference files (rdf:about with Description) does not override the known class
+func (m *Model) object(id string, class string) *Object {
+	if object, exists := m.objects[id]; exists {
+		if object.Class == "" || object.Class == "Description" {
+			object.Class = class
+		}
+		return object
+	}
+	object := &Object{Class: class, Id: id, Property: make(map[string]string)}
+	m.objects[id] = object
+	m.order = append(m.order, id)
+	return object
+}
+
+// ObjectById returns an object or nil
+func (m *Model) ObjectById(id string) *Object {
+	return m.objects[id]
+}
+
+// ObjectsByClass returns objects of the given classes in document order
+func (m *Model) ObjectsByClass(classes ...string) []*Object {
+	var result []*Object
+	for _, id := range m.order {
+		object := m.objects[id]
+		for _, class := range classes {
+			if object.Class == class {
+				result = append(result, object)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// TerminalsOf returns the terminals of conducting equipment ordered by ACDCTerminal.sequenceNumber
+func (m *Model) TerminalsOf(equipmentId string) []*Object {
+	return m.terminalsOfEqp[equipmentId]
+}
+
+// NominalVoltage of the equipment in kV from ConductingEquipment.BaseVoltage, 0 if unknown
+func (m *Model) NominalVoltage(equipment *Object) float64 {
+	baseVoltage := m.ObjectById(equipment.Get("ConductingEquipment.BaseVoltage"))
+	if baseVoltage == nil {
+		return 0
+	}
+	voltage, _ := strconv.ParseFloat(baseVoltage.Get("BaseVoltage.nominalVoltage"), 64)
+	return voltage
+}
+
+func sequenceNumber(terminal *Object) int {
+	number, err := strconv.Atoi(terminal.Get("ACDCTerminal.sequenceNumber"))
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+func attrRdf(t xml.StartElement, name string) string {
+	for _, attr := range t.Attr {
+		if attr.Name.Local == name && (attr.Name.Space == NamespaceRdf || attr.Name.Space == "rdf") {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package cim
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func parseFile(t *testing.T, path string) *Model {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	model, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return model
+}
+
+func TestParse(t *testing.T) {
+	model := parseFile(t, "testdata/radial.xml")
+
+	breaker := model.ObjectById("_BR1")
+	if breaker == nil {
+		t.Fatal("breaker _BR1 is not found")
+	}
+	if breaker.Class != "Breaker" || breaker.Name() != "BR 1" {
+		t.Errorf("breaker: class %s, name %s", breaker.Class, breaker.Name())
+	}
+	if breaker.Get("Switch.normalOpen") != "true" {
+		t.Errorf("the property of rdf:about is not merged: %v", breaker.Property)
+	}
+	if voltage := model.NominalVoltage(breaker); voltage != 10 {
+		t.Errorf("nominal voltage %g, expected 10", voltage)
+	}
+
+	if name := model.ObjectById("_LOAD1").Name(); name != "_LOAD1" {
+		t.Errorf("name of the object without IdentifiedObject.name %s, expected the id", name)
+	}
+
+	terminals := model.TerminalsOf("_BR1")
+	if len(terminals) != 2 || terminals[0].Id != "_T_BR1_1" || terminals[1].Id != "_T_BR1_2" {
+		t.Fatalf("terminals of _BR1 are not ordered by the sequence number: %v", terminals)
+	}
+	if node := terminals[0].Get("Terminal.ConnectivityNode"); node != "_CN1" {
+		t.Errorf("reference %s, expected _CN1", node)
+	}
+
+	if nodes := model.ObjectsByClass("ConnectivityNode"); len(nodes) != 4 || nodes[0].Id != "_CN1" {
+		t.Errorf("connectivity nodes are not in document order: %v", nodes)
+	}
+}
+
+func TestParseNotRdf(t *testing.T) {
+	if _, err := Parse(strings.NewReader("<model/>")); err == nil {
+		t.Fatal("expected an error for the root element other than rdf:RDF")
+	}
+}
+
+func TestParseEmpty(t *testing.T) {
+	if _, err := Parse(strings.NewReader(`<rdf:RDF xmlns:rdf="` + NamespaceRdf + `"/>`)); err == nil {
+		t.Fatal("expected an error for the model without objects")
+	}
+}
@@ -0,0 +1,73 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"grid_losses/types"
+	"math"
+)
+
+// BinaryMessageSize is the size of one message in the fixed-width layout
+const BinaryMessageSize = 48
+
+// BinaryCodec is the fixed-width little-endian layout, the frame is a sequence of messages:
+//
+//	0  id     uint64
+//	8  v      float32
+//	12 qds    uint32
+//	16 src    uint32
+//	20 s      int32
+//	24 e      int32
+//	28 gi     int32
+//	32 ts     int64, Unix ms
+//	40 tsr    int64, Unix ms
+type BinaryCodec struct{}
+
+func (BinaryCodec) Name() string {
+	return NameBinary
+}
+
+func (BinaryCodec) Marshal(messages []types.RtdbMessage) ([]byte, error) {
+	data := make([]byte, len(messages)*BinaryMessageSize)
+
+	for i, message := range messages {
+		b := data[i*BinaryMessageSize:]
+		binary.LittleEndian.PutUint64(b[0:], message.Id)
+		binary.LittleEndian.PutUint32(b[8:], math.Float32bits(message.Value))
+		binary.LittleEndian.PutUint32(b[12:], message.Quality)
+		binary.LittleEndian.PutUint32(b[16:], message.Source)
+		binary.LittleEndian.PutUint32(b[20:], uint32(int32(message.Select)))
+		binary.LittleEndian.PutUint32(b[24:], uint32(int32(message.Execute)))
+		binary.LittleEndian.PutUint32(b[28:], uint32(int32(message.TimestampFromClient)))
+		binary.LittleEndian.PutUint64(b[32:], uint64(unixMilli(message.Timestamp)))
+		binary.LittleEndian.PutUint64(b[40:], uint64(unixMilli(message.TimestampRecv)))
+	}
+
+	return data, nil
+}
+
+func (BinaryCodec) Unmarshal(data []byte) ([]types.RtdbMessage, error) {
+	if len(data)%BinaryMessageSize != 0 {
+		return nil, errors.New(fmt.Sprintf("codec: binary frame size %d is not a multiple of %d", len(data), BinaryMessageSize))
+	}
+
+	messages := make([]types.RtdbMessage, len(data)/BinaryMessageSize)
+
+	for i := range messages {
+		b := data[i*BinaryMessageSize:]
+		messages[i] = types.RtdbMessage{
+			Id:                  binary.LittleEndian.Uint64(b[0:]),
+			Value:               math.Float32frombits(binary.LittleEndian.Uint32(b[8:])),
+			Quality:             binary.LittleEndian.Uint32(b[12:]),
+			Source:              binary.LittleEndian.Uint32(b[16:]),
+			Select:              int(int32(binary.LittleEndian.Uint32(b[20:]))),
+			Execute:             int(int32(binary.LittleEndian.Uint32(b[24:]))),
+			TimestampFromClient: int(int32(binary.LittleEndian.Uint32(b[28:]))),
+			Timestamp:           fromUnixMilli(int64(binary.LittleEndian.Uint64(b[32:]))),
+			TimestampRecv:       fromUnixMilli(int64(binary.LittleEndian.Uint64(b[40:]))),
+		}
+	}
+
+	return messages, nil
+}
@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"grid_losses/types"
+	"time"
+)
+
+// Codec names
+const (
+	NameJson     = "json"
+	NameMsgpack  = "msgpack"
+	NameProtobuf = "protobuf"
+	NameBinary   = "binary"
+)
+
+// Codec encodes RtdbMessage arrays of one bus frame
+type Codec interface {
+	Name() string
+	Marshal(messages []types.RtdbMessage) ([]byte, error)
+	Unmarshal(data []byte) ([]types.RtdbMessage, error)
+}
+
+// ByName returns the codec, JSON if the name is empty
+func ByName(name string) (Codec, error) {
+	switch name {
+	case "", NameJson:
+		return JsonCodec{}, nil
+	case NameMsgpack:
+		return MsgpackCodec{}, nil
+	case NameProtobuf:
+		return ProtobufCodec{}, nil
+	case NameBinary:
+		return BinaryCodec{}, nil
+	}
+	return nil, errors.New(fmt.Sprintf("codec: unknown codec %s", name))
+}
+
+// unixMilli of the timestamp, 0 if the timestamp is not set
+func unixMilli(t types.IsoDate) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+func fromUnixMilli(ms int64) types.IsoDate {
+	if ms == 0 {
+		return types.IsoDate{}
+	}
+	return types.IsoDate{Time: time.UnixMilli(ms)}
+}
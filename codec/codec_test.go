@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"grid_losses/types"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	messages := []types.RtdbMessage{
+		{
+			Id:                  1001,
+			Value:               10.5,
+			Quality:             0x80,
+			Source:              7,
+			Timestamp:           types.IsoDate{Time: time.UnixMilli(1700000000123)},
+			TimestampRecv:       types.IsoDate{Time: time.UnixMilli(1700000000456)},
+			TimestampFromClient: 1,
+		},
+		{Id: 1002, Value: -1.25, Select: 1},
+		{Id: 1002, Value: 1, Execute: 1, Quality: 0x100},
+		{
+			Id:            1003,
+			Value:         0,
+			Timestamp:     types.IsoDate{Time: time.UnixMilli(-86400123)},
+			TimestampRecv: types.IsoDate{Time: time.UnixMilli(-1)},
+		},
+	}
+
+	for _, name := range []string{NameJson, NameMsgpack, NameProtobuf, NameBinary} {
+		t.Run(name, func(t *testing.T) {
+			codec, err := ByName(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := codec.Marshal(messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			decoded, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(decoded) != len(messages) {
+				t.Fatalf("decoded %d messages, expected %d", len(decoded), len(messages))
+			}
+
+			for i, expected := range messages {
+				if !isEqual(decoded[i], expected) {
+					t.Errorf("message %d: decoded %+v (ts %v, tsr %v), expected %+v (ts %v, tsr %v)", i,
+						decoded[i], decoded[i].Timestamp.Time, decoded[i].TimestampRecv.Time,
+						expected, expected.Timestamp.Time, expected.TimestampRecv.Time)
+				}
+			}
+		})
+	}
+}
+
+func TestEmptyFrame(t *testing.T) {
+	for _, name := range []string{NameJson, NameMsgpack, NameProtobuf, NameBinary} {
+		codec, _ := ByName(name)
+
+		data, err := codec.Marshal(nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		decoded, err := codec.Unmarshal(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(decoded) != 0 {
+			t.Errorf("%s: decoded %d messages from the empty frame", name, len(decoded))
+		}
+	}
+}
+
+func TestUnknownCodec(t *testing.T) {
+	if _, err := ByName("xml"); err == nil {
+		t.Fatal("expected an error for the unknown codec")
+	}
+}
+
+// isEqual compares the timestamps as instants, the codecs restore them in the local time zone
+func isEqual(a types.RtdbMessage, b types.RtdbMessage) bool {
+	if !a.Timestamp.Equal(b.Timestamp.Time) || !a.TimestampRecv.Equal(b.TimestampRecv.Time) {
+		return false
+	}
+	a.Timestamp, a.TimestampRecv = types.IsoDate{}, types.IsoDate{}
+	b.Timestamp, b.TimestampRecv = types.IsoDate{}, types.IsoDate{}
+	return a == b
+}
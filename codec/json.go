@@ -0,0 +1,21 @@
+package codec
+
+import (
+	"encoding/json"
+	"grid_losses/types"
+)
+
+// JsonCodec is the RTDB default: an array of objects with the timestamps in ISO format
+type JsonCodec struct{}
+
+func (JsonCodec) Name() string {
+	return NameJson
+}
+
+func (JsonCodec) Marshal(messages []types.RtdbMessage) ([]byte, error) {
+	return json.Marshal(messages)
+}
+
+func (JsonCodec) Unmarshal(data []byte) ([]types.RtdbMessage, error) {
+	return types.ParseScadaRtdbData(data)
+}
@@ -0,0 +1,66 @@
+package codec
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+	"grid_losses/types"
+)
+
+// MsgpackCodec is an array of maps with the JSON keys and the timestamps in Unix milliseconds
+type MsgpackCodec struct{}
+
+type msgpackMessage struct {
+	Timestamp           int64   `msgpack:"ts,omitempty"`
+	TimestampRecv       int64   `msgpack:"tsr,omitempty"`
+	Id                  uint64  `msgpack:"id"`
+	Value               float32 `msgpack:"v"`
+	Quality             uint32  `msgpack:"qds,omitempty"`
+	Source              uint32  `msgpack:"src,omitempty"`
+	Select              int     `msgpack:"s,omitempty"`
+	Execute             int     `msgpack:"e,omitempty"`
+	TimestampFromClient int     `msgpack:"gi,omitempty"`
+}
+
+func (MsgpackCodec) Name() string {
+	return NameMsgpack
+}
+
+func (MsgpackCodec) Marshal(messages []types.RtdbMessage) ([]byte, error) {
+	packed := make([]msgpackMessage, len(messages))
+	for i, message := range messages {
+		packed[i] = msgpackMessage{
+			Timestamp:           unixMilli(message.Timestamp),
+			TimestampRecv:       unixMilli(message.TimestampRecv),
+			Id:                  message.Id,
+			Value:               message.Value,
+			Quality:             message.Quality,
+			Source:              message.Source,
+			Select:              message.Select,
+			Execute:             message.Execute,
+			TimestampFromClient: message.TimestampFromClient,
+		}
+	}
+	return msgpack.Marshal(packed)
+}
+
+func (MsgpackCodec) Unmarshal(data []byte) ([]types.RtdbMessage, error) {
+	var packed []msgpackMessage
+	if err := msgpack.Unmarshal(data, &packed); err != nil {
+		return nil, err
+	}
+
+	messages := make([]types.RtdbMessage, len(packed))
+	for i, message := range packed {
+		messages[i] = types.RtdbMessage{
+			Timestamp:           fromUnixMilli(message.Timestamp),
+			TimestampRecv:       fromUnixMilli(message.TimestampRecv),
+			Id:                  message.Id,
+			Value:               message.Value,
+			Quality:             message.Quality,
+			Source:              message.Source,
+			Select:              message.Select,
+			Execute:             message.Execute,
+			TimestampFromClient: message.TimestampFromClient,
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,156 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protowire"
+	"grid_losses/types"
+	"math"
+)
+
+// ProtobufCodec is RtdbMessages of rtdb.proto. The messages are encoded with protowire
+// to avoid the generated code for one message type
+type ProtobufCodec struct{}
+
+// Field numbers of rtdb.proto
+const (
+	protoMessages            protowire.Number = 1
+	protoTimestamp           protowire.Number = 1
+	protoTimestampRecv       protowire.Number = 2
+	protoId                  protowire.Number = 3
+	protoValue               protowire.Number = 4
+	protoQuality             protowire.Number = 5
+	protoSource              protowire.Number = 6
+	protoSelect              protowire.Number = 7
+	protoExecute             protowire.Number = 8
+	protoTimestampFromClient protowire.Number = 9
+)
+
+func (ProtobufCodec) Name() string {
+	return NameProtobuf
+}
+
+func (ProtobufCodec) Marshal(messages []types.RtdbMessage) ([]byte, error) {
+	var data []byte
+	var b []byte
+
+	for _, message := range messages {
+		b = b[:0]
+		b = appendVarint(b, protoTimestamp, uint64(unixMilli(message.Timestamp)))
+		b = appendVarint(b, protoTimestampRecv, uint64(unixMilli(message.TimestampRecv)))
+		b = appendVarint(b, protoId, message.Id)
+		if message.Value != 0 {
+			b = protowire.AppendTag(b, protoValue, protowire.Fixed32Type)
+			b = protowire.AppendFixed32(b, math.Float32bits(message.Value))
+		}
+		b = appendVarint(b, protoQuality, uint64(message.Quality))
+		b = appendVarint(b, protoSource, uint64(message.Source))
+		b = appendVarint(b, protoSelect, protowire.EncodeZigZag(int64(message.Select)))
+		b = appendVarint(b, protoExecute, protowire.EncodeZigZag(int64(message.Execute)))
+		b = appendVarint(b, protoTimestampFromClient, protowire.EncodeZigZag(int64(message.TimestampFromClient)))
+
+		data = protowire.AppendTag(data, protoMessages, protowire.BytesType)
+		data = protowire.AppendBytes(data, b)
+	}
+
+	return data, nil
+}
+
+// appendVarint skips the default value as proto3 does
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func (ProtobufCodec) Unmarshal(data []byte) ([]types.RtdbMessage, error) {
+	messages := make([]types.RtdbMessage, 0)
+
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if num != protoMessages || typ != protowire.BytesType {
+			if n = protowire.ConsumeFieldValue(num, typ, data); n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		b, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		message, err := unmarshalProtobufMessage(b)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
+}
+
+func unmarshalProtobufMessage(b []byte) (types.RtdbMessage, error) {
+	var message types.RtdbMessage
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return message, protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		switch {
+		case typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return message, protowire.ParseError(n)
+			}
+			b = b[n:]
+
+			switch num {
+			case protoTimestamp:
+				message.Timestamp = fromUnixMilli(int64(v))
+			case protoTimestampRecv:
+				message.TimestampRecv = fromUnixMilli(int64(v))
+			case protoId:
+				message.Id = v
+			case protoQuality:
+				message.Quality = uint32(v)
+			case protoSource:
+				message.Source = uint32(v)
+			case protoSelect:
+				message.Select = int(protowire.DecodeZigZag(v))
+			case protoExecute:
+				message.Execute = int(protowire.DecodeZigZag(v))
+			case protoTimestampFromClient:
+				message.TimestampFromClient = int(protowire.DecodeZigZag(v))
+			}
+
+		case typ == protowire.Fixed32Type && num == protoValue:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return message, protowire.ParseError(n)
+			}
+			b = b[n:]
+			message.Value = math.Float32frombits(v)
+
+		default:
+			if n = protowire.ConsumeFieldValue(num, typ, b); n < 0 {
+				return message, errors.New(fmt.Sprintf("codec: field %d: %v", num, protowire.ParseError(n)))
+			}
+			b = b[n:]
+		}
+	}
+
+	return message, nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"grid_losses/llog"
+	"grid_losses/types"
+	"slices"
+	"time"
+)
+
+const DefaultCommandTimeoutSec = 10
+const CommandHistoryLength = 100
+
+var ErrCommandsDisabled = errors.New("commands are disabled")
+var ErrCommandsWithoutSourceId = errors.New("commands require rtdb.source_id to tell the confirmations from the own commands")
+
+// CommandState of select-before-operate
+type CommandState int
+
+const (
+	CommandStateSelecting CommandState = iota
+	CommandStateExecuting
+	CommandStateConfirmed
+	CommandStateRejected
+	CommandStateTimeout
+)
+
+func (state CommandState) String() string {
+	switch state {
+	case CommandStateSelecting:
+		return "selecting"
+	case CommandStateExecuting:
+		return "executing"
+	case CommandStateConfirmed:
+		return "confirmed"
+	case CommandStateRejected:
+		return "rejected"
+	case CommandStateTimeout:
+		return "timeout"
+	}
+	return fmt.Sprintf("unknown (%d)", int(state))
+}
+
+func (state CommandState) MarshalText() ([]byte, error) {
+	return []byte(state.String()), nil
+}
+
+// CommandStruct is a command to the control point of the equipment
+type CommandStruct struct {
+	Id          int          `json:"id"`
+	EquipmentId int          `json:"equipment"`
+	PointId     uint64       `json:"point"`
+	Value       float32      `json:"value"`
+	State       CommandState `json:"state"`
+	CreatedAt   time.Time    `json:"created"`
+	UpdatedAt   time.Time    `json:"updated"`
+}
+
+// CheckCommandConfig refuses the commands without the own source: the select looped back by the bus
+// would be taken for the confirmation of RTDB
+func (s *ThisService) CheckCommandConfig() error {
+	if s.config.GridLosses.Commands.Enabled && s.config.Rtdb.SourceId == 0 {
+		return ErrCommandsWithoutSourceId
+	}
+	return nil
+}
+
+// SendCommand starts select-before-operate of the control point of the equipment. Can be called from any goroutine.
+// The command is confirmed asynchronously, the state is available from Commands
+func (s *ThisService) SendCommand(equipmentId int, value float32) (CommandStruct, error) {
+	if !s.config.GridLosses.Commands.Enabled {
+		return CommandStruct{}, ErrCommandsDisabled
+	}
+
+	if len(s.config.GridLosses.Commands.Equipment) > 0 && !slices.Contains(s.config.GridLosses.Commands.Equipment, equipmentId) {
+		return CommandStruct{}, fmt.Errorf("commands to equipment %d are not allowed", equipmentId)
+	}
+
+	type result struct {
+		command CommandStruct
+		err     error
+	}
+
+	done := make(chan result)
+
+	if !s.updateModel(func() {
+		command, err := s.startCommand(equipmentId, value)
+		done <- result{command: command, err: err}
+	}) {
+		return CommandStruct{}, errors.New("the service is shutting down")
+	}
+
+	r := <-done
+	return r.command, r.err
+}
+
+// Commands returns the active and the recent commands
+func (s *ThisService) Commands() []CommandStruct {
+	done := make(chan []CommandStruct)
+
+	if !s.updateModel(func() {
+		commands := make([]CommandStruct, 0, len(s.commandFromPointId)+len(s.commandHistory))
+		commands = append(commands, s.commandHistory...)
+		for _, command := range s.commandFromPointId {
+			commands = append(commands, *command)
+		}
+		slices.SortFunc(commands, func(a, b CommandStruct) int { return a.Id - b.Id })
+		done <- commands
+	}) {
+		return nil
+	}
+
+	return <-done
+}
+
+// startCommand selects the control point. Must be called from ReceiveDataWorker
+func (s *ThisService) startCommand(equipmentId int, value float32) (CommandStruct, error) {
+	pointId, exists := s.pointFromEquipmentIdAndResourceTypeId[equipmentId][ResourceTypeControl]
+	if !exists {
+		return CommandStruct{}, fmt.Errorf("equipment %d has no control point", equipmentId)
+	}
+
+	if command, exists := s.commandFromPointId[pointId]; exists {
+		return CommandStruct{}, fmt.Errorf("command %d to point %d is in progress", command.Id, pointId)
+	}
+
+	s.commandSeq += 1
+	now := time.Now()
+
+	command := &CommandStruct{
+		Id:          s.commandSeq,
+		EquipmentId: equipmentId,
+		PointId:     pointId,
+		Value:       value,
+		State:       CommandStateSelecting,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	s.commandFromPointId[pointId] = command
+
+	llog.Logger.Infof("Command %d: select %d %s = %v", command.Id, pointId, s.pointNameFromPointId[pointId], value)
+
+	s.publishCommand(command, 1, 0)
+	return *command, nil
+}
+
+func (s *ThisService) publishCommand(command *CommandStruct, isSelect int, isExecute int) {
+	s.publishPoint(types.RtdbMessage{
+		Id:        command.PointId,
+		Value:     command.Value,
+		Timestamp: types.IsoDate{Time: time.Now()},
+		Select:    isSelect,
+		Execute:   isExecute,
+	})
+
+	id := command.Id
+	state := command.State
+
+	time.AfterFunc(s.commandTimeout(), func() {
+		s.updateModel(func() {
+			if command, exists := s.commandFromPointId[command.PointId]; exists && command.Id == id && command.State == state {
+				llog.Logger.Warnf("Command %d: %s is not confirmed in %v", id, state, s.commandTimeout())
+				s.completeCommand(command, CommandStateTimeout)
+			}
+		})
+	})
+}
+
+func (s *ThisService) commandTimeout() time.Duration {
+	if s.config.GridLosses.Commands.TimeoutSec > 0 {
+		return time.Duration(s.config.GridLosses.Commands.TimeoutSec) * time.Second
+	}
+	return DefaultCommandTimeoutSec * time.Second
+}
+
+// confirmCommand processes the confirmation of RTDB for the control point, the own command looped back by the bus
+// is not a confirmation. Must be called from ReceiveDataWorker
+func (s *ThisService) confirmCommand(command *CommandStruct, point types.RtdbMessage) {
+	if point.Source == s.config.Rtdb.SourceId {
+		llog.Logger.Debugf("Command %d: own message in state %s: %+v", command.Id, command.State, point)
+		return
+	}
+
+	isNegative := point.Quality&types.QualityInvalid != 0
+
+	switch {
+	case command.State == CommandStateSelecting && point.Select != 0:
+		if isNegative {
+			llog.Logger.Warnf("Command %d: select rejected", command.Id)
+			s.completeCommand(command, CommandStateRejected)
+			return
+		}
+		llog.Logger.Infof("Command %d: select confirmed, execute", command.Id)
+		command.State = CommandStateExecuting
+		command.UpdatedAt = time.Now()
+		s.publishCommand(command, 0, 1)
+
+	case command.State == CommandStateExecuting && point.Execute != 0:
+		if isNegative {
+			llog.Logger.Warnf("Command %d: execute rejected", command.Id)
+			s.completeCommand(command, CommandStateRejected)
+			return
+		}
+		llog.Logger.Infof("Command %d: execute confirmed", command.Id)
+		s.completeCommand(command, CommandStateConfirmed)
+
+	default:
+		llog.Logger.Debugf("Command %d: unexpected message in state %s: %+v", command.Id, command.State, point)
+	}
+}
+
+func (s *ThisService) completeCommand(command *CommandStruct, state CommandState) {
+	command.State = state
+	command.UpdatedAt = time.Now()
+
+	delete(s.commandFromPointId, command.PointId)
+
+	s.commandHistory = append(s.commandHistory, *command)
+	if len(s.commandHistory) > CommandHistoryLength {
+		s.commandHistory = s.commandHistory[len(s.commandHistory)-CommandHistoryLength:]
+	}
+}
@@ -2,25 +2,78 @@ package configuration
 
 type Configuration struct {
 	ConfigApi struct {
-		Url      []string `yaml:"url" env:"true"`
-		HostName string   `yaml:"hostname,omitempty" env:"true"`
-		UserName string   `yaml:"username" env:"true"`
-		Password string   `yaml:"password" env:"true"`
+		Url             []string          `yaml:"url" env:"true"`
+		HostName        string            `yaml:"hostname,omitempty" env:"true"`
+		UserName        string            `yaml:"username" env:"true"`
+		Password        string            `yaml:"password" env:"true"`
+		Retries         int               `yaml:"retries,omitempty" env:"true"`
+		RetryBackoffSec int               `yaml:"retry_backoff,omitempty" env:"true"`
+		CaFile          string            `yaml:"ca_file,omitempty" env:"true"`
+		CertFile        string            `yaml:"cert_file,omitempty" env:"true"`
+		KeyFile         string            `yaml:"key_file,omitempty" env:"true"`
+		InsecureTls     bool              `yaml:"insecure_skip_verify,omitempty" env:"true"`
+		TlsServerName   string            `yaml:"tls_server_name,omitempty" env:"true"`
+		Proxy           string            `yaml:"proxy,omitempty" env:"true"`
+		Headers         map[string]string `yaml:"headers,omitempty"`
 	} `yaml:"config_api"`
 	Rtdb struct {
-		Input  string `yaml:"input_bus"`
-		Output string `yaml:"output_bus"`
+		Transport        string   `yaml:"transport,omitempty" env:"true"` // zmq (default), mqtt or nats
+		Url              string   `yaml:"url,omitempty" env:"true"`       // MQTT or NATS broker
+		ClientId         string   `yaml:"client_id,omitempty" env:"true"`
+		UserName         string   `yaml:"username,omitempty" env:"true"`
+		Password         string   `yaml:"password,omitempty" env:"true"`
+		Input            string   `yaml:"input_bus"`
+		Output           string   `yaml:"output_bus"`
+		GiTimeoutSec     int      `yaml:"gi_timeout,omitempty"`    // Time to wait for the initial snapshot, 30 s by default
+		GiOnSilenceSec   int      `yaml:"gi_on_silence,omitempty"` // Send GI if no data was received longer, 0 - disabled
+		GiEndpoint       string   `yaml:"gi_endpoint,omitempty"`   // RTDB REQ/REP endpoint confirming GI, the input bus if empty
+		GiRetries        int      `yaml:"gi_retries,omitempty"`
+		GiAttemptMs      int      `yaml:"gi_attempt_timeout,omitempty"`    // Timeout of one GI attempt, ms
+		ReconnectRetries int      `yaml:"reconnect_retries,omitempty"`     // Attempts to recreate a failed socket, 0 - unlimited
+		ReconnectMaxMs   int      `yaml:"reconnect_backoff_max,omitempty"` // Maximal delay between the attempts, ms
+		SubscribeTopics  []string `yaml:"subscribe_topics,omitempty"`      // Topic prefixes of the point groups to receive
+		PointTopic       string   `yaml:"point_topic,omitempty"`           // Topic of a point, e.g. "point/%d/", subscribes to the used points
+		InputCodec       string   `yaml:"input_codec,omitempty"`           // json (default), msgpack, protobuf or binary
+		OutputCodec      string   `yaml:"output_codec,omitempty"`          // json (default), msgpack, protobuf or binary
+		TimestampFormat  string   `yaml:"timestamp_format,omitempty"`      // Published timestamps: rfc3339, rfc3339nano, unix, unixms or a Go layout
+		StrictTimestamp  bool     `yaml:"strict_timestamp,omitempty"`      // Drop the data with invalid timestamps instead of setting the quality flag
+		SourceId         uint32   `yaml:"source_id,omitempty"`             // Stamped in the published values, the values with it are dropped
+		SourceTimeoutSec int      `yaml:"source_timeout,omitempty"`        // A source with lower priority replaces a value older than it, 0 - never
+		Sources          []struct {
+			Id       uint32 `yaml:"id"`
+			Priority int    `yaml:"priority,omitempty"` // 0 for the sources not listed
+			Ignore   bool   `yaml:"ignore,omitempty"`
+		} `yaml:"sources,omitempty"`
+		PublishTopic     string `yaml:"publish_topic,omitempty"`       // Topic of the published points, %d is replaced with the point id
+		AcceptOutOfOrder bool   `yaml:"accept_out_of_order,omitempty"` // Apply the values older than the last one, only count them
 	} `yaml:"rtdb,omitempty"`
 	GridLosses struct {
-		LogLevel    string `yaml:"log" env:"true"`
-		QueueLength int    `yaml:"queue"`
-		ApiPrefix   string `yaml:"api_prefix"`
-		Losses      []struct {
-			Equipment int    `yaml:"equipment"`
-			VoltageAc uint64 `yaml:"voltage_ac"`
-			CurrentA  uint64 `yaml:"current_a"`
-			CosPhi    uint64 `yaml:"cos_phi"`
-			State     uint64 `yaml:"state"`
+		LogLevel           string `yaml:"log" env:"true"`
+		QueueLength        int    `yaml:"queue"`
+		InputQueuePolicy   string `yaml:"input_queue_policy,omitempty"`  // block (default), drop_oldest or coalesce
+		OutputQueuePolicy  string `yaml:"output_queue_policy,omitempty"` // block (default), drop_oldest or coalesce
+		QueueWarnPercent   int    `yaml:"queue_warn_percent,omitempty"`  // Warn when a queue is fuller, 80 by default
+		CalculationCycleMs int    `yaml:"calculation_cycle,omitempty"`   // Period of the losses calculation of the changed branches, ms, 0 - on every value
+		CalculationWorkers int    `yaml:"calculation_workers,omitempty"` // Goroutines calculating the losses sharded by feeder, 0 - ReceiveDataWorker only
+		ApiPrefix          string `yaml:"api_prefix"`
+		HttpListen         string `yaml:"http_listen,omitempty" env:"true"`
+		CachePath          string `yaml:"cache_path,omitempty" env:"true"`
+		CacheRetention     int    `yaml:"cache_retention,omitempty" env:"true"` // Number of snapshots to keep, 0 - keep all
+		ProfileUpdateSec   int    `yaml:"profile_update,omitempty" env:"true"`  // Period of the profile updates, 0 - disabled
+		Commands           struct {
+			Enabled    bool   `yaml:"enabled"`
+			TimeoutSec int    `yaml:"timeout,omitempty"`          // Time to wait for each confirmation, 10 s by default
+			Equipment  []int  `yaml:"equipment,omitempty"`        // Equipment allowed to be controlled, all if empty
+			Token      string `yaml:"token,omitempty" env:"true"` // Bearer token of POST /api/commands, the commands are not accepted over HTTP if empty
+		} `yaml:"commands,omitempty"`
+		Losses []struct {
+			Equipment    int    `yaml:"equipment"`
+			VoltageAc    uint64 `yaml:"voltage_ac"`
+			VoltageAcEnd uint64 `yaml:"voltage_ac_end,omitempty"`
+			CurrentA     uint64 `yaml:"current_a"`
+			CosPhi       uint64 `yaml:"cos_phi"`
+			State        uint64 `yaml:"state"`
+			Output       uint64 `yaml:"output,omitempty"` // RTDB point the losses are published to, only kept for the HTTP API if 0
 		} `yaml:"losses"`
 	} `yaml:"grid_losses"`
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"sync/atomic"
+	"time"
+)
+
+// CycleStruct collects the branches changed since the last calculation cycle
+type CycleStruct struct {
+	period          time.Duration // 0 - calculate on every value
+	isBranchChanged []bool
+	changedBranches []int
+	cycles          atomic.Uint64
+	coalesced       atomic.Uint64 // Values received for the branches already waiting for the cycle
+	lastBranches    atomic.Int64
+	lastDuration    atomic.Int64
+	maxDuration     atomic.Int64
+	overruns        atomic.Uint64 // Cycles longer than the period
+}
+
+// CycleStats is the snapshot of CycleStruct
+type CycleStats struct {
+	PeriodMs     int64   `json:"period_ms"`
+	Cycles       uint64  `json:"cycles"`
+	Coalesced    uint64  `json:"coalesced"`
+	LastBranches int64   `json:"last_branches"`
+	LastMs       float64 `json:"last_ms"`
+	MaxMs        float64 `json:"max_ms"`
+	Overruns     uint64  `json:"overruns"`
+}
+
+// CycleStats from any goroutine
+func (s *ThisService) CycleStats() CycleStats {
+	c := &s.cycle
+	return CycleStats{
+		PeriodMs:     c.period.Milliseconds(),
+		Cycles:       c.cycles.Load(),
+		Coalesced:    c.coalesced.Load(),
+		LastBranches: c.lastBranches.Load(),
+		LastMs:       float64(c.lastDuration.Load()) / float64(time.Millisecond),
+		MaxMs:        float64(c.maxDuration.Load()) / float64(time.Millisecond),
+		Overruns:     c.overruns.Load(),
+	}
+}
+
+// CreateCycle of the losses calculation from the configuration
+func (s *ThisService) CreateCycle() {
+	s.cycle.period = time.Duration(s.config.GridLosses.CalculationCycleMs) * time.Millisecond
+	s.cycle.isBranchChanged = make([]bool, len(s.branchLosses))
+	s.cycle.changedBranches = s.cycle.changedBranches[:0]
+
+	if s.cycle.period > 0 {
+		llog.Logger.Infof("Losses are calculated every %v", s.cycle.period)
+	}
+}
+
+// cycleTicker returns nil if the losses are calculated on every value
+func (s *ThisService) cycleTicker() *time.Ticker {
+	if s.cycle.period <= 0 {
+		return nil
+	}
+	return time.NewTicker(s.cycle.period)
+}
+
+// scheduleBranchLoss calculates the branch at once or in the next cycle. Must be called from ReceiveDataWorker
+func (s *ThisService) scheduleBranchLoss(idx int) {
+	c := &s.cycle
+	if c.period <= 0 {
+		s.CalculateBranchLoss(idx)
+		return
+	}
+
+	if c.isBranchChanged[idx] {
+		c.coalesced.Add(1)
+		return
+	}
+
+	c.isBranchChanged[idx] = true
+	c.changedBranches = append(c.changedBranches, idx)
+}
+
+// scheduleAllBranchLosses after the topology has been changed. Must be called from ReceiveDataWorker
+func (s *ThisService) scheduleAllBranchLosses() {
+	if s.cycle.period <= 0 {
+		s.CalculateAllBranchLosses()
+		return
+	}
+
+	for idx := range s.branchLosses {
+		s.scheduleBranchLoss(idx)
+	}
+}
+
+// RunCycle calculates the branches changed since the last cycle. Must be called from ReceiveDataWorker
+func (s *ThisService) RunCycle() {
+	c := &s.cycle
+	if len(c.changedBranches) == 0 {
+		return
+	}
+
+	startedAt := time.Now()
+
+	for _, idx := range c.changedBranches {
+		c.isBranchChanged[idx] = false
+	}
+	s.calculateBranches(c.changedBranches)
+
+	duration := time.Since(startedAt)
+
+	c.cycles.Add(1)
+	c.lastBranches.Store(int64(len(c.changedBranches)))
+	c.lastDuration.Store(int64(duration))
+	if int64(duration) > c.maxDuration.Load() {
+		c.maxDuration.Store(int64(duration))
+	}
+	c.changedBranches = c.changedBranches[:0]
+
+	if duration > c.period {
+		c.overruns.Add(1)
+		llog.Logger.Warnf("Losses calculation cycle took %v, longer than %v", duration.Round(time.Microsecond), c.period)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/PVKonovalov/topogrid"
+	"grid_losses/types"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type GeoJsonGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+type GeoJsonFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   GeoJsonGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type GeoJsonFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []GeoJsonFeature `json:"features"`
+}
+
+// energizedFrom returns the equipment names of the power nodes energizing the node
+func (s *ThisService) energizedFrom(nodeId int) []string {
+	poweredBy, err := s.topologyGrid.NodeIsPoweredBy(nodeId)
+	if err != nil {
+		return nil
+	}
+
+	sources := make([]string, 0, len(poweredBy))
+	for _, powerNodeId := range poweredBy {
+		sources = append(sources, s.topologyGrid.EquipmentNameByNodeId(powerNodeId))
+	}
+	return sources
+}
+
+// TopologyAsDot returns the current topology with switch states, energization sources and losses in GraphViz DOT
+func (s *ThisService) TopologyAsDot() string {
+	return s.topologyAsDot(s.BranchLossFromEquipmentId())
+}
+
+// topologyAsDot labels the branches with the losses, without them if nil
+func (s *ThisService) topologyAsDot(lossFromEquipmentId map[int]float64) string {
+	var dot strings.Builder
+
+	s.modelLock.RLock()
+	defer s.modelLock.RUnlock()
+
+	dot.WriteString("graph topology {\n  node [style=filled fontsize=10];\n  edge [fontsize=9];\n")
+
+	for _, node := range s.topologyProfile.Node {
+		sources := s.energizedFrom(node.Id)
+
+		label := node.EquipmentName
+		if label == "" {
+			label = fmt.Sprintf("%d", node.Id)
+		}
+		if loss, exists := lossFromEquipmentId[node.EquipmentId]; exists {
+			label += fmt.Sprintf("\nP=%.3f", loss)
+		}
+		if len(sources) != 0 {
+			label += "\n<- " + strings.Join(sources, ",")
+		}
+
+		shape := "point"
+		switch node.EquipmentTypeId {
+		case topogrid.TypePower:
+			shape = "doublecircle"
+		case topogrid.TypeConsumer:
+			shape = "triangle"
+		case topogrid.TypeLine:
+			shape = "box"
+		case EquipmentTypeTransformer:
+			shape = "circle"
+		}
+
+		fillColor := "white"
+		if len(sources) != 0 {
+			fillColor = "tomato"
+		}
+
+		if shape == "point" {
+			dot.WriteString(fmt.Sprintf("  n%d [shape=point fillcolor=%s xlabel=%q];\n", node.Id, fillColor, label))
+		} else {
+			dot.WriteString(fmt.Sprintf("  n%d [shape=%s fillcolor=%s label=%q];\n", node.Id, shape, fillColor, label))
+		}
+	}
+
+	for _, edge := range s.topologyProfile.Edge {
+		attributes := make([]string, 0, 3)
+
+		label := edge.EquipmentName
+		if loss, exists := lossFromEquipmentId[edge.EquipmentId]; exists && edge.EquipmentId != 0 {
+			label += fmt.Sprintf("\nP=%.3f", loss)
+		}
+		if label != "" {
+			attributes = append(attributes, fmt.Sprintf("label=%q", label))
+		}
+
+		switchState, isSwitch := s.switchState(edge)
+		if isSwitch && switchState == topogrid.SwitchStateOpen {
+			attributes = append(attributes, "style=dashed")
+		}
+
+		if electricalState, exists := s.topologyGrid.EquipmentElectricalStateByEquipmentId(edge.EquipmentId); exists &&
+			edge.EquipmentId != 0 && electricalState&topogrid.StateEnergized == topogrid.StateEnergized {
+			attributes = append(attributes, "color=red")
+		}
+
+		dot.WriteString(fmt.Sprintf("  n%d -- n%d [%s];\n", edge.Terminal1, edge.Terminal2, strings.Join(attributes, " ")))
+	}
+
+	dot.WriteString("}\n")
+
+	return dot.String()
+}
+
+// TopologyAsGeoJson returns nodes and edges having coordinates in the profile as GeoJSON feature collection
+func (s *ThisService) TopologyAsGeoJson() ([]byte, error) {
+	return s.topologyAsGeoJson(s.BranchLossFromEquipmentId())
+}
+
+// topologyAsGeoJson sets the losses property of the branches, none if nil
+func (s *ThisService) topologyAsGeoJson(lossFromEquipmentId map[int]float64) ([]byte, error) {
+	collection := GeoJsonFeatureCollection{Type: "FeatureCollection", Features: make([]GeoJsonFeature, 0)}
+
+	coordinatesFromNodeId := make(map[int][]float64)
+
+	s.modelLock.RLock()
+	defer s.modelLock.RUnlock()
+
+	for _, node := range s.topologyProfile.Node {
+		if len(node.Coordinates) < 2 {
+			continue
+		}
+		coordinatesFromNodeId[node.Id] = node.Coordinates
+
+		properties := map[string]interface{}{
+			"id":             node.Id,
+			"equipment_id":   node.EquipmentId,
+			"equipment_name": node.EquipmentName,
+			"equipment_type": node.EquipmentTypeId,
+			"energized_from": s.energizedFrom(node.Id),
+		}
+		if loss, exists := lossFromEquipmentId[node.EquipmentId]; exists {
+			properties["losses"] = loss
+		}
+
+		collection.Features = append(collection.Features, GeoJsonFeature{
+			Type:       "Feature",
+			Geometry:   GeoJsonGeometry{Type: "Point", Coordinates: node.Coordinates},
+			Properties: properties,
+		})
+	}
+
+	for _, edge := range s.topologyProfile.Edge {
+		coordinates := edge.Coordinates
+		if len(coordinates) < 2 {
+			coordinates1, exists1 := coordinatesFromNodeId[edge.Terminal1]
+			coordinates2, exists2 := coordinatesFromNodeId[edge.Terminal2]
+			if !exists1 || !exists2 {
+				continue
+			}
+			coordinates = [][]float64{coordinates1, coordinates2}
+		}
+
+		properties := map[string]interface{}{
+			"id":             edge.Id,
+			"terminal1":      edge.Terminal1,
+			"terminal2":      edge.Terminal2,
+			"equipment_id":   edge.EquipmentId,
+			"equipment_name": edge.EquipmentName,
+			"equipment_type": edge.EquipmentTypeId,
+		}
+		if switchState, isSwitch := s.switchState(edge); isSwitch {
+			properties["switch_state"] = switchState
+		}
+		if electricalState, exists := s.topologyGrid.EquipmentElectricalStateByEquipmentId(edge.EquipmentId); exists && edge.EquipmentId != 0 {
+			properties["electrical_state"] = electricalState
+		}
+		if loss, exists := lossFromEquipmentId[edge.EquipmentId]; exists && edge.EquipmentId != 0 {
+			properties["losses"] = loss
+		}
+
+		collection.Features = append(collection.Features, GeoJsonFeature{
+			Type:       "Feature",
+			Geometry:   GeoJsonGeometry{Type: "LineString", Coordinates: coordinates},
+			Properties: properties,
+		})
+	}
+
+	return json.Marshal(collection)
+}
+
+// switchState of the edge if the edge is a circuit breaker or a disconnect switch
+func (s *ThisService) switchState(edge types.EdgeStruct) (int, bool) {
+	if edge.EquipmentTypeId != topogrid.TypeCircuitBreaker && edge.EquipmentTypeId != topogrid.TypeDisconnectSwitch {
+		return 0, false
+	}
+	return s.topologyGrid.EquipmentSwitchStateByEquipmentId(edge.EquipmentId)
+}
+
+// ExportTopology to file. The format is selected by extension: .dot, .gv or .geojson, .json.
+// The losses are not exported: the file is written at the start before any value is received
+func (s *ThisService) ExportTopology(path string) error {
+	var data []byte
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		data = []byte(s.topologyAsDot(nil))
+	case ".geojson", ".json":
+		if data, err = s.topologyAsGeoJson(nil); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown export format '%s'", filepath.Ext(path))
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
@@ -5,9 +5,23 @@ go 1.23.1
 require (
 	github.com/PVKonovalov/localcache v0.0.0-20220829074116-8ab0d347d423
 	github.com/PVKonovalov/topogrid v1.0.0
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/nats-io/nats.go v1.42.0
 	github.com/pebbe/zmq4 v1.2.11
-	golang.org/x/sync v0.9.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.9
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require github.com/yourbasic/graph v0.0.0-20210606180040-8ecfec1c2869 // indirect
+require (
+	github.com/gorilla/websocket v1.5.3 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/nats-io/nkeys v0.4.11 // indirect
+	github.com/nats-io/nuid v1.0.1 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/yourbasic/graph v0.0.0-20210606180040-8ecfec1c2869 // indirect
+	golang.org/x/crypto v0.37.0 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/sync v0.7.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+)
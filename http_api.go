@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"grid_losses/bus"
+	"grid_losses/llog"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MaxCommandRequestSize limits the body of POST /api/commands
+const MaxCommandRequestSize = 4096
+
+// StartHttpApi serves the debugging endpoints
+func (s *ThisService) StartHttpApi(listen string) {
+	s.httpServer = &http.Server{Addr: listen, Handler: s.httpHandler()}
+
+	go func() {
+		llog.Logger.Infof("HTTP API is listening on %s", listen)
+		if err := s.httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			llog.Logger.Errorf("HTTP API stopped: %v", err)
+		}
+	}()
+}
+
+func (s *ThisService) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/topology.dot", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		_, _ = w.Write([]byte(s.TopologyAsDot()))
+	})
+
+	mux.HandleFunc("/api/topology.geojson", func(w http.ResponseWriter, r *http.Request) {
+		data, err := s.TopologyAsGeoJson()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/geo+json")
+		_, _ = w.Write(data)
+	})
+
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		stats := map[string]any{
+			"input":        s.InputStats(),
+			"input_queue":  s.inputDataQueue.Stats(),
+			"switch_queue": s.switchDataQueue.Stats(),
+			"output_queue": s.outputDataQueue.Stats(),
+			"output":       s.OutputStats(),
+			"cycle":        s.CycleStats(),
+		}
+		if counter, ok := s.bus.(bus.DroppedCounter); ok {
+			stats["bus_dropped"] = counter.Dropped()
+		}
+		writeJson(w, http.StatusOK, stats)
+	})
+
+	if s.config.GridLosses.Commands.Enabled {
+		if s.config.GridLosses.Commands.Token == "" {
+			llog.Logger.Warnf("Commands are not accepted over HTTP without grid_losses.commands.token")
+		}
+		mux.HandleFunc("/api/commands", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				writeJson(w, http.StatusOK, s.Commands())
+			case http.MethodPost:
+				if !s.isCommandAuthorized(r) {
+					w.Header().Set("WWW-Authenticate", "Bearer")
+					http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+					return
+				}
+				if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+					http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+					return
+				}
+				var request CommandRequest
+				if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, MaxCommandRequestSize)).Decode(&request); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				if request.Equipment == nil || request.Value == nil {
+					http.Error(w, "equipment and value are required", http.StatusBadRequest)
+					return
+				}
+				command, err := s.SendCommand(*request.Equipment, *request.Value)
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusConflict)
+					return
+				}
+				writeJson(w, http.StatusAccepted, command)
+			default:
+				w.Header().Set("Allow", "GET, POST")
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			}
+		})
+	}
+
+	return mux
+}
+
+// CommandRequest is the body of POST /api/commands
+type CommandRequest struct {
+	Equipment *int     `json:"equipment"`
+	Value     *float32 `json:"value"`
+}
+
+// isCommandAuthorized if the request has the configured bearer token. The commands are refused without the token
+func (s *ThisService) isCommandAuthorized(r *http.Request) bool {
+	token := s.config.GridLosses.Commands.Token
+	if token == "" {
+		return false
+	}
+	received, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return isBearer && subtle.ConstantTimeCompare([]byte(received), []byte(token)) == 1
+}
+
+func writeJson(w http.ResponseWriter, status int, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
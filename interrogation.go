@@ -0,0 +1,136 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultGiTimeoutSec = 30
+
+// InterrogationStruct tracks the points received after the general interrogation (GI) of RTDB
+type InterrogationStruct struct {
+	generation                int
+	startedAt                 time.Time
+	pendingPointIds           map[uint64]bool
+	isInProgress              bool
+	isInitialSnapshotComplete bool
+	lastReceivedAt            atomic.Int64 // Unix nano of the last message received from the bus
+	isRequested               atomic.Bool
+}
+
+// usedPointIds returns the points used by the topology and the losses calculation
+func (s *ThisService) usedPointIds() map[uint64]bool {
+	pointIds := make(map[uint64]bool, len(s.resourceStructFromPointId)+len(s.branchLossIdxArrayFromPointId))
+	for pointId := range s.resourceStructFromPointId {
+		pointIds[pointId] = true
+	}
+	for pointId := range s.branchLossIdxArrayFromPointId {
+		pointIds[pointId] = true
+	}
+	return pointIds
+}
+
+// StartInterrogation sends GI to RTDB and starts tracking the received points.
+// Must be called from ReceiveDataWorker or before it is started
+func (s *ThisService) StartInterrogation(reason string) {
+	gi := &s.interrogation
+
+	gi.generation += 1
+	gi.startedAt = time.Now()
+	gi.pendingPointIds = s.usedPointIds()
+	gi.isInProgress = true
+
+	llog.Logger.Infof("General interrogation (%s): waiting for %d points", reason, len(gi.pendingPointIds))
+
+	if len(gi.pendingPointIds) == 0 {
+		s.completeInterrogation(false)
+	}
+
+	go func() {
+		defer gi.isRequested.Store(false)
+
+		if err := s.bus.Interrogate(); err != nil {
+			llog.Logger.Errorf("Failed to send general interrogation to RTDB: %v", err)
+			return
+		}
+		llog.Logger.Debugf("General interrogation sent to RTDB")
+	}()
+
+	generation := gi.generation
+	timeout := time.Duration(s.config.Rtdb.GiTimeoutSec) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultGiTimeoutSec * time.Second
+	}
+
+	time.AfterFunc(timeout, func() {
+		s.updateModel(func() {
+			if gi.generation == generation && gi.isInProgress {
+				s.completeInterrogation(true)
+			}
+		})
+	})
+}
+
+// RequestInterrogation from any goroutine. Repeated requests are ignored until GI is sent
+func (s *ThisService) RequestInterrogation(reason string) {
+	if !s.interrogation.isRequested.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		s.updateModel(func() {
+			s.StartInterrogation(reason)
+		})
+	}()
+}
+
+// trackInterrogation removes the received point from the pending ones. Must be called from ReceiveDataWorker
+func (s *ThisService) trackInterrogation(pointId uint64) {
+	gi := &s.interrogation
+	if !gi.isInProgress {
+		return
+	}
+
+	delete(gi.pendingPointIds, pointId)
+
+	if len(gi.pendingPointIds) == 0 {
+		s.completeInterrogation(false)
+	}
+}
+
+func (s *ThisService) completeInterrogation(isTimeout bool) {
+	gi := &s.interrogation
+	gi.isInProgress = false
+
+	if isTimeout {
+		llog.Logger.Warnf("General interrogation timeout: %d points were not received in %v",
+			len(gi.pendingPointIds), time.Since(gi.startedAt).Round(time.Millisecond))
+		for pointId := range gi.pendingPointIds {
+			llog.Logger.Debugf("Point was not received: %d %s", pointId, s.pointNameFromPointId[pointId])
+		}
+	} else {
+		llog.Logger.Infof("General interrogation completed in %v", time.Since(gi.startedAt).Round(time.Millisecond))
+	}
+
+	if !gi.isInitialSnapshotComplete {
+		gi.isInitialSnapshotComplete = true
+		llog.Logger.Infof("Initial snapshot is complete, publishing losses")
+		s.CalculateAllBranchLosses()
+	}
+}
+
+// detectMissedData requests GI if the bus was silent longer than the configured period. Called from the bus handler
+func (s *ThisService) detectMissedData() {
+	now := time.Now().UnixNano()
+	last := s.interrogation.lastReceivedAt.Swap(now)
+
+	silence := time.Duration(s.config.Rtdb.GiOnSilenceSec) * time.Second
+	if silence <= 0 || last == 0 {
+		return
+	}
+
+	if gap := time.Duration(now - last); gap > silence {
+		llog.Logger.Warnf("No data from RTDB for %v, data may have been missed", gap.Round(time.Second))
+		s.RequestInterrogation("data gap")
+	}
+}
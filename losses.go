@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/PVKonovalov/topogrid"
+	"grid_losses/llog"
+	"grid_losses/types"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const LossCountersFile = "loss-counters.json"
+
+// BranchLossStruct describes a branch from the configuration and its last calculated losses
+// Ploss21 = √3*(U1ac-U2ac)*Ia*cosφ1
+// energy is the losses integrated over time (the value unit multiplied by hours)
+type BranchLossStruct struct {
+	equipmentId  int
+	voltageAc    uint64
+	voltageAcEnd uint64
+	currentA     uint64
+	cosPhi       uint64
+	state        uint64
+	output       uint64
+	value        float64
+	energy       float64
+	timestamp    time.Time
+}
+
+// LossCounterStruct is the saved state of the branch losses
+type LossCounterStruct struct {
+	Equipment int       `json:"equipment"`
+	Output    uint64    `json:"output,omitempty"`
+	Value     float64   `json:"value"`
+	Energy    float64   `json:"energy"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CreateBranchLossesFromConfig creates branches and the mapping from the points used in the calculation
+func (s *ThisService) CreateBranchLossesFromConfig() {
+	for _, loss := range s.config.GridLosses.Losses {
+		idx := len(s.branchLosses)
+
+		s.branchLosses = append(s.branchLosses, BranchLossStruct{
+			equipmentId:  loss.Equipment,
+			voltageAc:    loss.VoltageAc,
+			voltageAcEnd: loss.VoltageAcEnd,
+			currentA:     loss.CurrentA,
+			cosPhi:       loss.CosPhi,
+			state:        loss.State,
+			output:       loss.Output,
+		})
+
+		for _, pointId := range []uint64{loss.VoltageAc, loss.VoltageAcEnd, loss.CurrentA, loss.CosPhi, loss.State} {
+			if pointId != 0 {
+				s.branchLossIdxArrayFromPointId[pointId] = append(s.branchLossIdxArrayFromPointId[pointId], idx)
+			}
+		}
+	}
+}
+
+// pointValue returns the last received value of the point or 0 if the point is not configured or not received yet
+func (s *ThisService) pointValue(pointId uint64) float64 {
+	if pointId == 0 {
+		return 0
+	}
+	return s.pointStore.Value(pointId)
+}
+
+// branchIsEnergized checks the state point of the branch and the electrical state of its equipment in the topology
+func (s *ThisService) branchIsEnergized(branch *BranchLossStruct) bool {
+	if branch.state != 0 {
+		if point, exists := s.pointStore.Get(branch.state); exists && point.Value == 0 {
+			return false
+		}
+	}
+
+	s.modelLock.RLock()
+	defer s.modelLock.RUnlock()
+
+	if s.topologyGrid != nil && branch.equipmentId != 0 {
+		if electricalState, exists := s.topologyGrid.EquipmentElectricalStateByEquipmentId(branch.equipmentId); exists {
+			return electricalState&topogrid.StateEnergized == topogrid.StateEnergized
+		}
+	}
+
+	return true
+}
+
+// CalculateBranchLoss calculates losses of the branch and sends the result to the output point.
+// Publication is held until the initial snapshot of the points is received.
+// Called from ReceiveDataWorker or from a calculation worker while ReceiveDataWorker waits for it
+func (s *ThisService) CalculateBranchLoss(idx int) {
+	branch := &s.branchLosses[idx]
+
+	// The points are read one by one from the locked pointStore: SwitchDataWorker may store a switch state
+	// between the reads, the losses are recalculated once ReceiveDataWorker gets the switched states.
+	// Only the result is written under the lock so the shards are calculated in parallel
+	var value float64
+
+	if s.branchIsEnergized(branch) {
+		value = math.Sqrt(3) *
+			(s.pointValue(branch.voltageAc) - s.pointValue(branch.voltageAcEnd)) *
+			s.pointValue(branch.currentA) *
+			s.pointValue(branch.cosPhi)
+	}
+
+	s.lossLock.Lock()
+	now := time.Now()
+	if !branch.timestamp.IsZero() {
+		branch.energy += branch.value * now.Sub(branch.timestamp).Hours()
+	}
+
+	branch.value = value
+	branch.timestamp = now
+	output := branch.output
+	timestamp := branch.timestamp
+	s.lossLock.Unlock()
+
+	if output != 0 && s.outputDataQueue != nil && s.interrogation.isInitialSnapshotComplete {
+		s.publishPoint(types.RtdbMessage{
+			Id:        output,
+			Value:     float32(value),
+			Timestamp: types.IsoDate{Time: timestamp},
+		})
+	}
+}
+
+// CalculateAllBranchLosses after the topology has been changed
+func (s *ThisService) CalculateAllBranchLosses() {
+	idxArray := make([]int, len(s.branchLosses))
+	for idx := range idxArray {
+		idxArray[idx] = idx
+	}
+	s.calculateBranches(idxArray)
+}
+
+// BranchLossFromEquipmentId returns the last calculated losses for each configured equipment
+func (s *ThisService) BranchLossFromEquipmentId() map[int]float64 {
+	s.lossLock.RLock()
+	defer s.lossLock.RUnlock()
+
+	lossFromEquipmentId := make(map[int]float64, len(s.branchLosses))
+	for _, branch := range s.branchLosses {
+		if branch.equipmentId != 0 {
+			lossFromEquipmentId[branch.equipmentId] += branch.value
+		}
+	}
+	return lossFromEquipmentId
+}
+
+// SaveLossCounters saves the accumulated losses of the branches to the cache directory
+func (s *ThisService) SaveLossCounters() error {
+	s.lossLock.RLock()
+	counters := make([]LossCounterStruct, 0, len(s.branchLosses))
+	for _, branch := range s.branchLosses {
+		energy := branch.energy
+		if !branch.timestamp.IsZero() {
+			energy += branch.value * time.Since(branch.timestamp).Hours()
+		}
+		counters = append(counters, LossCounterStruct{
+			Equipment: branch.equipmentId,
+			Output:    branch.output,
+			Value:     branch.value,
+			Energy:    energy,
+			Timestamp: branch.timestamp,
+		})
+	}
+	s.lossLock.RUnlock()
+
+	data, err := json.MarshalIndent(counters, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(s.cachePath, 0755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.cachePath, LossCountersFile)
+	if err = os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	if err = os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+
+	llog.Logger.Infof("Loss counters of %d branches saved to %s", len(counters), path)
+	return nil
+}
+
+// LoadLossCounters restores the accumulated losses of the branches with the same equipment and output point.
+// The losses are not integrated over the time the service was stopped
+func (s *ThisService) LoadLossCounters() error {
+	data, err := os.ReadFile(filepath.Join(s.cachePath, LossCountersFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	var counters []LossCounterStruct
+	if err = json.Unmarshal(data, &counters); err != nil {
+		return err
+	}
+
+	s.lossLock.Lock()
+	defer s.lossLock.Unlock()
+
+	restored := 0
+	for _, counter := range counters {
+		for idx := range s.branchLosses {
+			branch := &s.branchLosses[idx]
+			if branch.equipmentId == counter.Equipment && branch.output == counter.Output {
+				branch.energy = counter.Energy
+				restored += 1
+				break
+			}
+		}
+	}
+
+	llog.Logger.Infof("Loss counters of %d branches restored", restored)
+	return nil
+}
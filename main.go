@@ -1,26 +1,35 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/PVKonovalov/localcache"
 	"github.com/PVKonovalov/topogrid"
+	"grid_losses/bus"
+	"grid_losses/codec"
 	"grid_losses/configuration"
 	"grid_losses/llog"
+	"grid_losses/point_queue"
+	"grid_losses/profile_cache"
 	"grid_losses/types"
 	"grid_losses/webapi"
-	"grid_losses/zmq_bus"
+	"net/http"
 	"os"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
-const ApiGetTopology = "/api/topology/graph"
-const ApiGetEquipment = "/api/equipment"
 const ApiTimeoutSec = 60
 
+const DefaultCachePath = "cache"
+const CacheTopologyFile = "flisr-topology.json"
+const CacheEquipmentFile = "flisr-equipment.json"
+
 // Resource Types
 const (
 	ResourceTypeIsNotDefine      int = 0
@@ -34,272 +43,213 @@ const (
 	ResourceTypeStateLineSegment int = 8
 )
 
-type EdgeStruct struct {
-	EquipmentType           string `json:"equipment_type,omitempty"`
-	EquipmentName           string `json:"equipment_name,omitempty"`
-	EquipmentId             int    `json:"equipment_id,omitempty"`
-	EquipmentTypeId         int    `json:"equipment_type_id,omitempty"`
-	EquipmentVoltageClassId int    `json:"equipment_voltage_class_id,omitempty"`
-	Id                      int    `json:"id"`
-	StateNormal             int    `json:"state_normal"`
-	Terminal1               int    `json:"terminal1"`
-	Terminal2               int    `json:"terminal2"`
-}
-
-type TopologyStruct struct {
-	Edge []EdgeStruct `json:"edge"`
-	Node []struct {
-		EquipmentId             int    `json:"equipment_id,omitempty"`
-		EquipmentName           string `json:"equipment_name,omitempty"`
-		EquipmentTypeId         int    `json:"equipment_type_id,omitempty"`
-		EquipmentVoltageClassId int    `json:"equipment_voltage_class_id,omitempty"`
-		Id                      int    `json:"id"`
-	} `json:"node"`
-}
-
-type EquipmentStruct struct {
-	electricalState       uint32
-	groundedFrom          map[int]bool
-	energizedFrom         map[int]bool
-	EquipmentType         string `json:"equipment_type,omitempty"`
-	EquipmentVoltageClass string `json:"equipment_voltage_class"`
-	Id                    int    `json:"id"`
-	Name                  string `json:"name"`
-	TypeId                int    `json:"type_id,omitempty"`
-	VoltageClassId        int    `json:"voltage_class_id"`
-	Resource              []struct {
-		Id          int    `json:"id"`
-		Point       string `json:"point"`
-		PointId     uint64 `json:"point_id"`
-		PointTypeId int    `json:"point_type_id"`
-		Type        string `json:"type"`
-		TypeId      int    `json:"type_id"`
-	} `json:"resource,omitempty"`
-}
-
 type ResourceStruct struct {
 	equipmentId    int
 	resourceTypeId int
 }
 
+// ThisService state is owned by ReceiveDataWorker, other goroutines pass their changes through modelUpdateQueue.
+// The state read by the bus handler and the HTTP API is changed under modelLock, the branch losses under lossLock
 type ThisService struct {
 	config                                configuration.Configuration
-	topologyProfile                       *TopologyStruct
-	equipmentFromEquipmentId              map[int]EquipmentStruct
+	topologyProfile                       *types.TopologyStruct
+	equipmentFromEquipmentId              map[int]types.EquipmentStruct
 	pointNameFromPointId                  map[uint64]string
 	resourceStructFromPointId             map[uint64]ResourceStruct
 	pointFromEquipmentIdAndResourceTypeId map[int]map[int]uint64
 	equipmentIdArrayFromResourceTypeId    map[int][]int
+	equipmentIdFromControlPointId         map[uint64]int
 	numberOfCBCheckingLink                int
 	topologyFlisr                         *topogrid.TopologyGridStruct
 	topologyGrid                          *topogrid.TopologyGridStruct
-	zmq                                   *zmq_bus.ZmqBus
-	inputDataQueue                        chan types.RtdbMessage
-	outputDataQueue                       chan types.RtdbMessage
-	switchDataQueue                       chan types.RtdbMessage
+	bus                                   bus.Transport
+	inputBusCodec                         codec.Codec // Encoding of the points published to RTDB input
+	outputBusCodec                        codec.Codec // Encoding of the points received from RTDB output
+	inputDataQueue                        *point_queue.Queue
+	outputDataQueue                       *point_queue.Queue
+	switchDataQueue                       *point_queue.Queue
+	switchedQueue                         chan SwitchedStruct // Switch states applied to the topology
+	pointStore                            *PointStoreStruct
+	cycle                                 CycleStruct
+	calculation                           CalculationStruct
+	branchLosses                          []BranchLossStruct
+	branchLossIdxArrayFromPointId         map[uint64][]int
+	lossLock                              sync.RWMutex
+	cacheSnapshot                         *profile_cache.Snapshot
+	profileLoader                         *webapi.ProfileLoader
+	profileUpdater                        *ProfileUpdaterStruct
+	modelUpdateQueue                      chan func()
+	modelLock                             sync.RWMutex
+	cachePath                             string
+	interrogation                         InterrogationStruct
+	commandFromPointId                    map[uint64]*CommandStruct // Active commands
+	commandHistory                        []CommandStruct
+	commandSeq                            int
+	sourceFromSourceId                    map[uint32]SourceStruct
+	inputStats                            InputStatsStruct
+	outputStats                           OutputStatsStruct
+	httpServer                            *http.Server
+	shutdown                              chan struct{}
+	receiveWorkerDone                     chan struct{}
+	outputWorkerDone                      chan struct{}
 }
 
 // NewService grid Losses service
 func NewService() *ThisService {
 	return &ThisService{
-		equipmentFromEquipmentId:              make(map[int]EquipmentStruct),
+		equipmentFromEquipmentId:              make(map[int]types.EquipmentStruct),
 		pointNameFromPointId:                  make(map[uint64]string),
 		resourceStructFromPointId:             make(map[uint64]ResourceStruct),
 		pointFromEquipmentIdAndResourceTypeId: make(map[int]map[int]uint64),
 		equipmentIdArrayFromResourceTypeId:    make(map[int][]int),
+		equipmentIdFromControlPointId:         make(map[uint64]int),
+		commandFromPointId:                    make(map[uint64]*CommandStruct),
+		sourceFromSourceId:                    make(map[uint32]SourceStruct),
+		pointStore:                            NewPointStore(0),
+		branchLossIdxArrayFromPointId:         make(map[uint64][]int),
+		cacheSnapshot:                         profile_cache.NewSnapshot(),
+		modelUpdateQueue:                      make(chan func()),
+		inputBusCodec:                         codec.JsonCodec{},
+		outputBusCodec:                        codec.JsonCodec{},
+		shutdown:                              make(chan struct{}),
+		receiveWorkerDone:                     make(chan struct{}),
+		outputWorkerDone:                      make(chan struct{}),
 	}
 }
 
-func ParseTopologyData(data []byte) (*TopologyStruct, error) {
-	var topologyStruct TopologyStruct
-	err := json.Unmarshal(data, &topologyStruct)
-	return &topologyStruct, err
-}
-
-func ParseEquipmentData(data []byte) (*[]EquipmentStruct, error) {
-	var equipmentStructs []EquipmentStruct
-	err := json.Unmarshal(data, &equipmentStructs)
-	return &equipmentStructs, err
+// SetTopologyData parses topology profile data and replaces topologyProfile
+func (s *ThisService) SetTopologyData(data []byte) error {
+	topologyProfile, err := types.ParseTopologyData(data)
+	if err != nil {
+		return err
+	}
+	s.topologyProfile = topologyProfile
+	return nil
 }
 
-// LoadTopologyProfile Loading topologyProfile from configs.configAPIHostList
-func (s *ThisService) LoadTopologyProfile(timeoutSec time.Duration, isLoadFromCache bool, cachePath string) error {
-	var topologyData []byte
-
-	cache := localcache.New(cachePath)
-
-	if isLoadFromCache {
-		llog.Logger.Infof("Loading topologyProfile from local cache (%s)", cachePath)
-		profileData, err := cache.Load()
-		if err != nil {
-			return err
-		}
-		s.topologyProfile, err = ParseTopologyData(profileData)
+// SetEquipmentData parses equipment profile data and adds equipment to equipmentFromEquipmentId
+func (s *ThisService) SetEquipmentData(data []byte) error {
+	equipments, err := types.ParseEquipmentData(data)
+	if err != nil {
 		return err
 	}
+	s.setEquipments(*equipments)
+	return nil
+}
 
-	resultErr := errors.New("unknown error. Check configuration file")
+func (s *ThisService) setEquipments(equipments []types.EquipmentStruct) {
+	for _, _equipment := range equipments {
+		s.equipmentFromEquipmentId[_equipment.Id] = _equipment
+	}
+}
 
-	var err error
+// LoadProfiles Loading topology and equipment profiles from the same host of config.ConfigApi.Url.
+// Falls back to the local cache if no host returned both profiles.
+func (s *ThisService) LoadProfiles(isLoadFromCache bool, cachePath string, isLoadTopology bool, isLoadEquipment bool) error {
+	topologyCache := localcache.New(filepath.Join(cachePath, CacheTopologyFile))
+	equipmentCache := localcache.New(filepath.Join(cachePath, CacheEquipmentFile))
 
-	for _, urlAPIHost := range s.config.ConfigApi.Url {
-		urlAPIHost = strings.TrimSpace(urlAPIHost)
-		api := webapi.Connection{
-			Timeout:         timeoutSec,
-			BaseUrl:         urlAPIHost,
-			HostVirtualName: s.config.ConfigApi.HostName}
+	topologyPath := s.config.GridLosses.ApiPrefix + webapi.ApiGetTopology
+	equipmentPath := s.config.GridLosses.ApiPrefix + webapi.ApiGetEquipment
 
-		llog.Logger.Debugf("Logon to %s as %s", api.BaseUrl, s.config.ConfigApi.UserName)
-		_, err, _ = api.Logon(s.config.ConfigApi.UserName, s.config.ConfigApi.Password)
-		if err != nil {
-			llog.Logger.Errorf("Failed to logon: %v", err)
-			resultErr = err
-			continue
+	parsers := make(map[string]webapi.ParseFunc, 2)
+	if isLoadTopology {
+		parsers[topologyPath] = func(data []byte) (any, error) {
+			return types.ParseTopologyData(data)
 		}
-
-		llog.Logger.Debugf("Getting topology profile ...")
-		topologyData, err = api.GetProfile(s.config.GridLosses.ApiPrefix + ApiGetTopology)
-
-		if err != nil {
-			llog.Logger.Errorf("Failed to get topology profile: %v", err)
-			resultErr = err
-			continue
+	}
+	if isLoadEquipment {
+		parsers[equipmentPath] = func(data []byte) (any, error) {
+			return types.ParseEquipmentData(data)
 		}
+	}
 
-		s.topologyProfile, err = ParseTopologyData(topologyData)
+	if len(parsers) == 0 {
+		return nil
+	}
 
-		if err != nil {
-			llog.Logger.Errorf("Failed to unmarshal topology profile: %v", err)
-			resultErr = err
-			continue
-		} else {
-			resultErr = nil
-			break
+	if !isLoadFromCache {
+		err := s.loadProfilesFromApi(parsers, topologyPath, equipmentPath, topologyCache, equipmentCache)
+		if err == nil {
+			return nil
 		}
+		llog.Logger.Errorf("Failed to load profiles from API host: %v", err)
 	}
 
-	if resultErr == nil {
-		err = cache.Save(topologyData)
-
+	if isLoadTopology {
+		llog.Logger.Infof("Loading topology profile from local cache (%s)", topologyCache.PathToCache)
+		profileData, err := topologyCache.Load()
 		if err != nil {
-			llog.Logger.Errorf("Failed to write to local cache (%s)", cachePath)
-			resultErr = err
+			return err
 		}
-	} else {
-		llog.Logger.Errorf("Failed to load topology profile from API host: %v", resultErr)
-		llog.Logger.Infof("Loading from local cache (%s)", cachePath)
-		profileData, err := cache.Load()
-
-		if err != nil {
+		if err = s.SetTopologyData(profileData); err != nil {
 			return err
 		}
-
-		s.topologyProfile, err = ParseTopologyData(profileData)
-		resultErr = err
 	}
 
-	if cache.IsChanged {
-		llog.Logger.Infof("Configuration changed from the previous loading")
-	}
-
-	return resultErr
-}
-
-// LoadEquipmentProfile Loading equipment from config.ConfigAPIHostList
-func (s *ThisService) LoadEquipmentProfile(timeoutSec time.Duration, isLoadFromCache bool, cachePath string) error {
-	var equipmentData []byte
-	var equipments *[]EquipmentStruct
-
-	cache := localcache.New(cachePath)
-
-	if isLoadFromCache {
-		llog.Logger.Infof("Loading equipment from local cache (%s)", cachePath)
-		profileData, err := cache.Load()
+	if isLoadEquipment {
+		llog.Logger.Infof("Loading equipment profile from local cache (%s)", equipmentCache.PathToCache)
+		profileData, err := equipmentCache.Load()
 		if err != nil {
 			return err
 		}
-		equipments, err = ParseEquipmentData(profileData)
-		if err == nil {
-			for _, _equipment := range *equipments {
-				s.equipmentFromEquipmentId[_equipment.Id] = _equipment
-			}
+		if err = s.SetEquipmentData(profileData); err != nil {
+			return err
 		}
-		return err
 	}
 
-	var err error
-	resultErr := errors.New("unknown error. Check configuration file")
+	return nil
+}
 
-	for _, urlAPIHost := range s.config.ConfigApi.Url {
-		urlAPIHost = strings.TrimSpace(urlAPIHost)
-		api := webapi.Connection{
-			Timeout:         timeoutSec,
-			BaseUrl:         urlAPIHost,
-			HostVirtualName: s.config.ConfigApi.HostName}
+// loadProfilesFromApi from the first host returned all the profiles parsed without errors
+func (s *ThisService) loadProfilesFromApi(parsers map[string]webapi.ParseFunc, topologyPath string, equipmentPath string,
+	topologyCache *localcache.LocalCache, equipmentCache *localcache.LocalCache) error {
 
-		llog.Logger.Debugf("Logon to %s as %s", api.BaseUrl, s.config.ConfigApi.UserName)
-		_, err, _ = api.Logon(s.config.ConfigApi.UserName, s.config.ConfigApi.Password)
-		if err != nil {
-			llog.Logger.Errorf("Failed to logon: %v", err)
-			resultErr = err
-			continue
-		}
+	llog.Logger.Debugf("Getting profiles from %v as %s ...", s.profileLoader.HostsByHealth(), s.config.ConfigApi.UserName)
 
-		llog.Logger.Debugf("Getting equipment profile ...")
-		equipmentData, err = api.GetProfile(s.config.GridLosses.ApiPrefix + ApiGetEquipment)
+	result, err := s.profileLoader.Load(parsers)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			llog.Logger.Errorf("Failed to get equipment: %v", err)
-			resultErr = err
-			continue
-		}
+	llog.Logger.Infof("Profiles loaded from %s", result.Host)
 
-		equipments, err = ParseEquipmentData(equipmentData)
+	_, isTopologyFromApi := result.Profiles[topologyPath]
+	_, isEquipmentFromApi := result.Profiles[equipmentPath]
 
-		if err != nil {
-			llog.Logger.Errorf("Failed to unmarshal equipment: %v", err)
-			resultErr = err
-			continue
-		} else {
-			for _, _equipment := range *equipments {
-				s.equipmentFromEquipmentId[_equipment.Id] = _equipment
-			}
-			resultErr = nil
-			break
-		}
+	s.profileUpdater = &ProfileUpdaterStruct{
+		host:                        result.Host,
+		topology:                    result.Validators[topologyPath],
+		equipment:                   result.Validators[equipmentPath],
+		isTopologyFromApi:           isTopologyFromApi,
+		isEquipmentFromApi:          isEquipmentFromApi,
+		isTopologyChangesSupported:  true,
+		isEquipmentChangesSupported: true,
 	}
 
-	if resultErr == nil {
-		err := cache.Save(equipmentData)
-
-		if err != nil {
-			llog.Logger.Errorf("Failed to write to local cache (%s)", cachePath)
-			resultErr = err
+	if topology, exists := result.Profiles[topologyPath]; exists {
+		topologyData := result.Data[topologyPath]
+		s.topologyProfile = topology.(*types.TopologyStruct)
+		s.cacheSnapshot.Add(CacheTopologyFile, result.Host+topologyPath, topologyData)
+		if err = topologyCache.Save(topologyData); err != nil {
+			llog.Logger.Errorf("Failed to write to local cache (%s)", topologyCache.PathToCache)
 		}
-	} else {
-		llog.Logger.Errorf("Failed to load equipment from API host: %v", resultErr)
-		llog.Logger.Infof("Loading from local cache (%s)", cachePath)
-		profileData, err := cache.Load()
+	}
 
-		if err != nil {
-			return err
+	if equipments, exists := result.Profiles[equipmentPath]; exists {
+		equipmentData := result.Data[equipmentPath]
+		s.setEquipments(*equipments.(*[]types.EquipmentStruct))
+		s.cacheSnapshot.Add(CacheEquipmentFile, result.Host+equipmentPath, equipmentData)
+		if err = equipmentCache.Save(equipmentData); err != nil {
+			llog.Logger.Errorf("Failed to write to local cache (%s)", equipmentCache.PathToCache)
 		}
-
-		equipments, err = ParseEquipmentData(profileData)
-
-		if err == nil {
-			for _, _equipment := range *equipments {
-				s.equipmentFromEquipmentId[_equipment.Id] = _equipment
-			}
-		}
-		resultErr = err
 	}
 
-	if cache.IsChanged {
+	if topologyCache.IsChanged || equipmentCache.IsChanged {
 		llog.Logger.Infof("Configuration changed from the previous loading")
 	}
 
-	return resultErr
+	return nil
 }
 
 func (s *ThisService) CreateInternalParametersFromProfiles() {
@@ -324,6 +274,15 @@ func (s *ThisService) CreateInternalParametersFromProfiles() {
 					s.numberOfCBCheckingLink += 1
 				}
 			}
+			if resource.TypeId == ResourceTypeControl {
+				s.pointNameFromPointId[resource.PointId] = resource.Point
+				s.equipmentIdFromControlPointId[resource.PointId] = equipment.Id
+
+				if _, exists := s.pointFromEquipmentIdAndResourceTypeId[equipment.Id]; !exists {
+					s.pointFromEquipmentIdAndResourceTypeId[equipment.Id] = make(map[int]uint64)
+				}
+				s.pointFromEquipmentIdAndResourceTypeId[equipment.Id][resource.TypeId] = resource.PointId
+			}
 			if _, exists := s.equipmentIdArrayFromResourceTypeId[resource.TypeId]; !exists {
 				s.equipmentIdArrayFromResourceTypeId[resource.TypeId] = make([]int, 0)
 			}
@@ -332,85 +291,236 @@ func (s *ThisService) CreateInternalParametersFromProfiles() {
 	}
 }
 
-func (s *ThisService) LoadTopologyGrid() error {
-	s.topologyFlisr = topogrid.New(len(s.topologyProfile.Node))
+// NewTopologyGrid creates the topology from the profile with the normal switch states
+func NewTopologyGrid(topologyProfile *types.TopologyStruct) (*topogrid.TopologyGridStruct, error) {
+	topologyGrid := topogrid.New(len(topologyProfile.Node))
 
-	for _, node := range s.topologyProfile.Node {
-		s.topologyFlisr.AddNode(node.Id, node.EquipmentId, node.EquipmentTypeId, node.EquipmentName)
+	for _, node := range topologyProfile.Node {
+		topologyGrid.AddNode(node.Id, node.EquipmentId, node.EquipmentTypeId, node.EquipmentName)
 	}
 
-	for _, edge := range s.topologyProfile.Edge {
-		if err := s.topologyFlisr.AddEdge(edge.Id, edge.Terminal1, edge.Terminal2, edge.StateNormal, edge.EquipmentId, edge.EquipmentTypeId, edge.EquipmentName); err != nil {
-			return err
+	for _, edge := range topologyProfile.Edge {
+		if err := topologyGrid.AddEdge(edge.Id, edge.Terminal1, edge.Terminal2, edge.StateNormal, edge.EquipmentId, edge.EquipmentTypeId, edge.EquipmentName); err != nil {
+			return nil, err
 		}
 	}
+	return topologyGrid, nil
+}
 
-	s.topologyGrid = topogrid.New(len(s.topologyProfile.Node))
-
-	for _, node := range s.topologyProfile.Node {
-		s.topologyGrid.AddNode(node.Id, node.EquipmentId, node.EquipmentTypeId, node.EquipmentName)
+func (s *ThisService) LoadTopologyGrid() error {
+	topologyFlisr, err := NewTopologyGrid(s.topologyProfile)
+	if err != nil {
+		return err
 	}
 
-	for _, edge := range s.topologyProfile.Edge {
-		if err := s.topologyGrid.AddEdge(edge.Id, edge.Terminal1, edge.Terminal2, edge.StateNormal, edge.EquipmentId, edge.EquipmentTypeId, edge.EquipmentName); err != nil {
-			return err
-		}
+	topologyGrid, err := NewTopologyGrid(s.topologyProfile)
+	if err != nil {
+		return err
 	}
+
+	s.topologyFlisr = topologyFlisr
+	s.topologyGrid = topologyGrid
 	return nil
 }
 
-func (s *ThisService) ZmqReceiveDataHandler(msg []string) {
+// SetTimestampFormat of the RTDB messages from the configuration
+func (s *ThisService) SetTimestampFormat() error {
+	types.IsStrictTimestamp = s.config.Rtdb.StrictTimestamp
+	return types.SetIsoDateFormat(s.config.Rtdb.TimestampFormat)
+}
+
+func (s *ThisService) ReceiveDataHandler(msg []string) {
+	s.detectMissedData()
+
 	for _, data := range msg {
-		_message, err := types.ParseScadaRtdbData([]byte(data))
+		_message, err := s.outputBusCodec.Unmarshal([]byte(data))
 		if err != nil {
-			llog.Logger.Errorf("Failed to parse incoming data (%s): %v", data, err)
+			llog.Logger.Errorf("Failed to parse incoming data (%q): %v", data, err)
 			continue
 		}
 		for _, point := range _message {
-			if _, exists := s.resourceStructFromPointId[point.Id]; exists {
-				s.inputDataQueue <- point
+			if point.Quality&types.QualityTimestampInvalid != 0 {
+				llog.Logger.Debugf("Invalid timestamp of point %d", point.Id)
 			}
+			if queue := s.inputQueue(point.Id); queue != nil && s.isSourceAccepted(point) {
+				if err = queue.Push(point); err != nil {
+					llog.Logger.Warnf("Point %d was dropped: %v", point.Id, err)
+				}
+			}
+		}
+	}
+}
+
+// BusStateHandler logs the state changes of the bus connections and requests GI after the subscriber is reconnected
+func (s *ThisService) BusStateHandler(endpoint string, isSubscriber bool, state bus.State, err error) {
+	switch state {
+	case bus.StateReconnecting:
+		llog.Logger.Warnf("Bus [%s] %s: %v", endpoint, state, err)
+	case bus.StateFailed:
+		llog.Logger.Errorf("Bus [%s] %s: %v", endpoint, state, err)
+	default:
+		llog.Logger.Infof("Bus [%s] %s", endpoint, state)
+		if isSubscriber {
+			s.RequestInterrogation("reconnect")
+		}
+	}
+}
+
+// inputQueue returns switchDataQueue for the switch states, inputDataQueue for the other points used by
+// the topology or the losses calculation and nil for the points not used
+func (s *ThisService) inputQueue(pointId uint64) *point_queue.Queue {
+	s.modelLock.RLock()
+	defer s.modelLock.RUnlock()
+
+	if resource, exists := s.resourceStructFromPointId[pointId]; exists {
+		if resource.resourceTypeId == ResourceTypeState {
+			return s.switchDataQueue
 		}
+		return s.inputDataQueue
 	}
+	if _, exists := s.branchLossIdxArrayFromPointId[pointId]; exists {
+		return s.inputDataQueue
+	}
+	if s.config.GridLosses.Commands.Enabled {
+		if _, exists := s.equipmentIdFromControlPointId[pointId]; exists {
+			return s.inputDataQueue
+		}
+	}
+	return nil
 }
 
+// ReceiveDataWorker applies incoming points, the switch states applied by SwitchDataWorker, the model updates and
+// runs the calculation cycles in the same goroutine until inputDataQueue is closed and SwitchDataWorker is stopped
 func (s *ThisService) ReceiveDataWorker() {
-	for point := range s.inputDataQueue {
-		if resource, exists := s.resourceStructFromPointId[point.Id]; exists {
-			switch resource.resourceTypeId {
-			case ResourceTypeState:
-				llog.Logger.Debugf("Toggle: %+v", point)
-
-				if err := s.topologyGrid.SetSwitchStateByEquipmentId(resource.equipmentId, int(point.Value)); err != nil {
-					llog.Logger.Warnf("Failed to change state: %v", err)
-					continue
-				}
+	defer close(s.receiveWorkerDone)
 
-				s.topologyGrid.SetEquipmentElectricalState()
+	s.startCalculationWorkers()
+	defer s.stopCalculationWorkers()
 
-			case ResourceTypeMeasure:
-				llog.Logger.Debugf("Measure: %+v", point)
+	var cycle <-chan time.Time
+	if ticker := s.cycleTicker(); ticker != nil {
+		defer ticker.Stop()
+		cycle = ticker.C
+	}
+
+	input := s.inputDataQueue.Ready()
+	switched := s.switchedQueue
+
+	for input != nil || switched != nil {
+		select {
+		case <-input:
+			point, ok := s.inputDataQueue.Pop()
+			if !ok {
+				if s.inputDataQueue.IsDone() {
+					input = nil
+				}
+				continue
 			}
+			s.ProcessPoint(point)
+		case result, ok := <-switched:
+			if !ok {
+				switched = nil
+				continue
+			}
+			s.applySwitched(result)
+		case update := <-s.modelUpdateQueue:
+			update()
+		case <-cycle:
+			s.RunCycle()
 		}
 	}
+
+	s.RunCycle()
+}
+
+func (s *ThisService) ProcessPoint(point types.RtdbMessage) {
+	if command, exists := s.commandFromPointId[point.Id]; exists {
+		s.confirmCommand(command, point)
+		return
+	}
+
+	if !s.isInOrder(point) {
+		s.trackInterrogation(point.Id)
+		return
+	}
+
+	if !s.isPriorityAccepted(point) {
+		return
+	}
+
+	s.pointStore.Set(point)
+	s.trackInterrogation(point.Id)
+
+	if resource, exists := s.resourceStructFromPointId[point.Id]; exists && resource.resourceTypeId == ResourceTypeMeasure {
+		llog.Logger.Debugf("Measure: %+v", point)
+	}
+
+	for _, idx := range s.branchLossIdxArrayFromPointId[point.Id] {
+		s.scheduleBranchLoss(idx)
+	}
 }
 
 func (s *ThisService) OutputEventWorker() {
+	defer close(s.outputWorkerDone)
 
-	for event := range s.outputDataQueue {
+	for {
+		<-s.outputDataQueue.Ready()
 
-		data, err := json.Marshal([]types.RtdbMessage{event})
-		if err != nil {
-			llog.Logger.Fatalf("Failed to marshal (%+v): %v", event, err)
+		for event, ok := s.outputDataQueue.Pop(); ok; event, ok = s.outputDataQueue.Pop() {
+			s.publishEvent(event)
 		}
 
-		_, err = s.zmq.Send(0, data)
-		if err != nil {
-			llog.Logger.Fatalf("Failed to send event (%+v): %v", event, err)
+		if s.outputDataQueue.IsDone() {
+			return
 		}
 	}
 }
 
+// OutputStatsStruct counts the events published by OutputEventWorker
+type OutputStatsStruct struct {
+	published atomic.Uint64
+	failed    atomic.Uint64 // Events not marshalled or not sent, they are not retried
+}
+
+// OutputStats is the snapshot of OutputStatsStruct
+type OutputStats struct {
+	Published uint64 `json:"published"`
+	Failed    uint64 `json:"failed"`
+}
+
+// OutputStats from any goroutine
+func (s *ThisService) OutputStats() OutputStats {
+	return OutputStats{
+		Published: s.outputStats.published.Load(),
+		Failed:    s.outputStats.failed.Load(),
+	}
+}
+
+// publishEvent to RTDB. The failed event is logged and counted, the worker continues with the next one
+func (s *ThisService) publishEvent(event types.RtdbMessage) {
+	event.Source = s.config.Rtdb.SourceId
+
+	data, err := s.inputBusCodec.Marshal([]types.RtdbMessage{event})
+	if err != nil {
+		s.outputStats.failed.Add(1)
+		llog.Logger.Errorf("Failed to marshal (%+v): %v", event, err)
+		return
+	}
+
+	topic := ""
+	if s.config.Rtdb.PublishTopic != "" {
+		topic = PointTopic(s.config.Rtdb.PublishTopic, event.Id)
+	}
+
+	if err = s.bus.Publish(topic, data); err != nil {
+		s.outputStats.failed.Add(1)
+		llog.Logger.Errorf("Failed to send event (%+v): %v", event, err)
+		return
+	}
+
+	s.outputStats.published.Add(1)
+}
+
 func main() {
 
 	s := NewService()
@@ -420,10 +530,24 @@ func main() {
 	var pathToConfig string
 	var isLoadFromCache bool
 	var showEnvVars bool
+	var topologyFile string
+	var equipmentFile string
+	var cimFile string
+	var exportFile string
+	var cacheSnapshotId string
+	var listCacheSnapshots bool
+	var scenarioFile string
 
 	flag.StringVar(&pathToConfig, "conf", "grid_losses.yml", "path to yml configuration file")
 	flag.BoolVar(&isLoadFromCache, "cache", false, "load profile from the local cache")
+	flag.StringVar(&cacheSnapshotId, "cache-snapshot", "", "load profile from the cache snapshot with id (or 'latest')")
+	flag.BoolVar(&listCacheSnapshots, "cache-list", false, "show a list of the cache snapshots")
 	flag.BoolVar(&showEnvVars, "env", false, "show a list of configuration parameters loaded from the environment")
+	flag.StringVar(&topologyFile, "topology", "", "load topology profile from JSON file")
+	flag.StringVar(&equipmentFile, "equipment", "", "load equipment profile from JSON file")
+	flag.StringVar(&cimFile, "cim", "", "load topology and equipment from CIM (CGMES) RDF/XML file")
+	flag.StringVar(&exportFile, "export", "", "export topology with the normal switch states to .dot or .geojson file and exit")
+	flag.StringVar(&scenarioFile, "scenario", "", "run the scenario over the memory bus and exit")
 	flag.Parse()
 
 	if showEnvVars {
@@ -431,6 +555,15 @@ func main() {
 		os.Exit(0)
 	}
 
+	if scenarioFile != "" {
+		if err = RunScenario(scenarioFile); err != nil {
+			llog.Logger.Errorf("Scenario %s failed:\n%v", scenarioFile, err)
+			os.Exit(1)
+		}
+		llog.Logger.Infof("Scenario %s passed", scenarioFile)
+		os.Exit(0)
+	}
+
 	if err = s.config.LoadFromFile(pathToConfig); err != nil {
 		llog.Logger.Fatalf("Failed to read configuration (%s): %v", pathToConfig, err)
 	}
@@ -446,44 +579,209 @@ func main() {
 
 	llog.Logger.Infof("Log level: %s", llog.Logger.GetLevel().UpperString())
 
-	if err = s.LoadTopologyProfile(time.Second*ApiTimeoutSec, isLoadFromCache, "cache/flisr-topology.json"); err != nil {
-		llog.Logger.Fatalf("Failed to load topology profile: %v", err)
+	if err = s.SetTimestampFormat(); err != nil {
+		llog.Logger.Fatalf("Failed to set timestamp format: %v", err)
 	}
 
-	if err = s.LoadEquipmentProfile(time.Second*ApiTimeoutSec, isLoadFromCache, "cache/flisr-equipment.json"); err != nil {
-		llog.Logger.Fatalf("Failed to load equipment profile: %v", err)
+	if err = s.CheckCommandConfig(); err != nil {
+		llog.Logger.Fatalf("Failed to enable commands: %v", err)
+	}
+
+	cachePath := s.config.GridLosses.CachePath
+	if cachePath == "" {
+		cachePath = DefaultCachePath
+	}
+
+	cacheStore := profile_cache.New(cachePath, s.config.GridLosses.CacheRetention)
+	s.cachePath = cachePath
+
+	if listCacheSnapshots {
+		snapshots, err := cacheStore.List()
+		if err != nil {
+			llog.Logger.Fatalf("Failed to list cache snapshots (%s): %v", cachePath, err)
+		}
+		for _, snapshot := range snapshots {
+			fmt.Printf("%s %s\n", snapshot.Id, snapshot.Timestamp.Local().Format(time.RFC3339))
+			for name, file := range snapshot.Files {
+				fmt.Printf("  %-24s %8d %08x %s\n", name, file.Size, file.ChecksumIEEE, file.SourceUrl)
+			}
+		}
+		os.Exit(0)
+	}
+
+	if cimFile != "" {
+		if err = s.LoadProfilesFromCimFile(cimFile); err != nil {
+			llog.Logger.Fatalf("Failed to load CIM model (%s): %v", cimFile, err)
+		}
+	} else if cacheSnapshotId != "" {
+		if err = s.LoadProfilesFromCacheSnapshot(cacheStore, cacheSnapshotId); err != nil {
+			llog.Logger.Fatalf("Failed to load cache snapshot (%s): %v", cacheSnapshotId, err)
+		}
+	} else {
+		if topologyFile != "" {
+			if err = s.LoadTopologyProfileFromFile(topologyFile); err != nil {
+				llog.Logger.Fatalf("Failed to load topology profile: %v", err)
+			}
+		}
+
+		if equipmentFile != "" {
+			if err = s.LoadEquipmentProfileFromFile(equipmentFile); err != nil {
+				llog.Logger.Fatalf("Failed to load equipment profile: %v", err)
+			}
+		}
+
+		s.profileLoader = webapi.NewProfileLoader(
+			s.config.ConfigApi.Url,
+			s.config.ConfigApi.HostName,
+			s.config.ConfigApi.UserName,
+			s.config.ConfigApi.Password,
+			time.Second*ApiTimeoutSec)
+
+		s.profileLoader.Retries = s.config.ConfigApi.Retries
+		s.profileLoader.Tls = webapi.TlsOptions{
+			CaFile:             s.config.ConfigApi.CaFile,
+			CertFile:           s.config.ConfigApi.CertFile,
+			KeyFile:            s.config.ConfigApi.KeyFile,
+			InsecureSkipVerify: s.config.ConfigApi.InsecureTls,
+			ServerName:         s.config.ConfigApi.TlsServerName,
+		}
+		s.profileLoader.Proxy = s.config.ConfigApi.Proxy
+		s.profileLoader.Headers = s.config.ConfigApi.Headers
+
+		if s.config.ConfigApi.InsecureTls {
+			llog.Logger.Warnf("TLS certificate verification of the configuration API is disabled")
+		}
+
+		if s.config.ConfigApi.RetryBackoffSec > 0 {
+			s.profileLoader.BackoffMin = time.Duration(s.config.ConfigApi.RetryBackoffSec) * time.Second
+		}
+
+		if err = s.LoadProfiles(isLoadFromCache, cachePath, topologyFile == "", equipmentFile == ""); err != nil {
+			llog.Logger.Fatalf("Failed to load profiles: %v", err)
+		}
+
+		// A snapshot is loaded with both profiles, so it is not saved if one of them is given by a file
+		if !s.cacheSnapshot.Has(CacheTopologyFile, CacheEquipmentFile) {
+			llog.Logger.Debugf("Cache snapshot is not saved: not all profiles are loaded from the configuration API")
+		} else if saved, err := cacheStore.Save(s.cacheSnapshot); err != nil {
+			llog.Logger.Errorf("Failed to save cache snapshot (%s): %v", cachePath, err)
+		} else if saved {
+			llog.Logger.Infof("Cache snapshot %s saved", s.cacheSnapshot.Id)
+		}
 	}
 
 	s.CreateInternalParametersFromProfiles()
+	s.CreateBranchLossesFromConfig()
+	s.CreateSourcesFromConfig()
+	s.CreatePointStore()
+	s.CreateCycle()
+
+	if err = s.LoadLossCounters(); err != nil {
+		llog.Logger.Warnf("Failed to load loss counters (%s): %v", cachePath, err)
+	}
 
-	s.inputDataQueue = make(chan types.RtdbMessage, s.config.GridLosses.QueueLength)
-	s.outputDataQueue = make(chan types.RtdbMessage, s.config.GridLosses.QueueLength)
-	s.switchDataQueue = make(chan types.RtdbMessage, s.config.GridLosses.QueueLength)
+	if err = s.CreateQueues(); err != nil {
+		llog.Logger.Fatalf("Failed to create queues: %v", err)
+	}
 
 	if err = s.LoadTopologyGrid(); err != nil {
 		llog.Logger.Fatalf("Failed to load topology: %v", err)
 	}
 
-	if s.zmq, err = zmq_bus.New(1, 1); err != nil {
-		llog.Logger.Fatalf("Failed to create zmq context: %v", err)
+	s.topologyGrid.SetEquipmentElectricalState()
+
+	if exportFile != "" {
+		if err = s.ExportTopology(exportFile); err != nil {
+			llog.Logger.Fatalf("Failed to export topology (%s): %v", exportFile, err)
+		}
+		llog.Logger.Infof("Topology exported to %s", exportFile)
+		os.Exit(0)
+	}
+
+	if s.inputBusCodec, err = codec.ByName(s.config.Rtdb.InputCodec); err != nil {
+		llog.Logger.Fatalf("Failed to set codec of RTDB input: %v", err)
 	}
 
-	var subscriberIdx int
-	if subscriberIdx, err = s.zmq.AddSubscriber(s.config.Rtdb.Output); err != nil {
-		llog.Logger.Fatalf("Failed to add zmq subscriber [%s]: %v", s.config.Rtdb.Output, err)
+	if s.outputBusCodec, err = codec.ByName(s.config.Rtdb.OutputCodec); err != nil {
+		llog.Logger.Fatalf("Failed to set codec of RTDB output: %v", err)
 	}
 
-	s.zmq.SetReceiveHandler(subscriberIdx, s.ZmqReceiveDataHandler)
+	llog.Logger.Infof("Codecs: RTDB input %s, RTDB output %s", s.inputBusCodec.Name(), s.outputBusCodec.Name())
+
+	options := bus.Options{
+		Transport:           s.config.Rtdb.Transport,
+		Url:                 s.config.Rtdb.Url,
+		ClientId:            s.config.Rtdb.ClientId,
+		UserName:            s.config.Rtdb.UserName,
+		Password:            s.config.Rtdb.Password,
+		Input:               s.config.Rtdb.Input,
+		Output:              s.config.Rtdb.Output,
+		GiEndpoint:          s.config.Rtdb.GiEndpoint,
+		GiTimeout:           time.Duration(s.config.Rtdb.GiAttemptMs) * time.Millisecond,
+		GiRetries:           s.config.Rtdb.GiRetries,
+		ReconnectRetries:    s.config.Rtdb.ReconnectRetries,
+		ReconnectBackoffMax: time.Duration(s.config.Rtdb.ReconnectMaxMs) * time.Millisecond,
+	}
+
+	if options.ClientId == "" {
+		options.ClientId = "grid_losses"
+	}
 
-	if _, err = s.zmq.AddPublisher(s.config.Rtdb.Input); err != nil {
-		llog.Logger.Fatalf("Failed to add zmq event publisher [%s]: %v", s.config.Rtdb.Input, err)
+	if s.bus, err = bus.New(options); err != nil {
+		llog.Logger.Fatalf("Failed to create %s bus: %v", s.config.Rtdb.Transport, err)
 	}
 
+	s.bus.SetStateHandler(s.BusStateHandler)
+
+	var topics []string
+	if s.IsTopicSubscription() {
+		topics = s.SubscribeTopics()
+		llog.Logger.Infof("Subscribing to %d topics", len(topics))
+	}
+
+	if err = s.bus.Subscribe(topics, s.ReceiveDataHandler); err != nil {
+		llog.Logger.Fatalf("Failed to subscribe to RTDB output [%s]: %v", s.config.Rtdb.Output, err)
+	}
+
+	s.StartInterrogation("startup")
+
+	go s.SwitchDataWorker()
 	go s.ReceiveDataWorker()
+	go s.OutputEventWorker()
+
+	if s.config.GridLosses.ProfileUpdateSec > 0 && s.profileUpdater != nil {
+		go s.ProfileUpdateWorker(time.Duration(s.config.GridLosses.ProfileUpdateSec) * time.Second)
+	}
+
+	if s.config.GridLosses.HttpListen != "" {
+		s.StartHttpApi(s.config.GridLosses.HttpListen)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 
 	llog.Logger.Infof("Started")
 
-	err = s.zmq.WaitingLoop()
+	err = s.bus.Run(ctx)
+
+	// The next signal terminates the service immediately
+	stop()
+
+	exitCode := ExitCodeOk
+
+	if ctx.Err() != nil {
+		llog.Logger.Infof("Stopping on signal")
+	} else {
+		llog.Logger.Errorf("Stopped: %v", err)
+		exitCode = ExitCodeBusFailure
+	}
+
+	if err = s.Shutdown(ShutdownTimeoutSec * time.Second); err != nil {
+		llog.Logger.Errorf("Failed to stop gracefully: %v", err)
+		if exitCode == ExitCodeOk {
+			exitCode = ExitCodeShutdownFailure
+		}
+	}
 
-	llog.Logger.Errorf("Stopped: %v", err)
+	llog.Logger.Infof("Stopped")
+	os.Exit(exitCode)
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"grid_losses/types"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestScenariosConcurrently runs the scenarios while the HTTP API is read and the profiles are updated,
+// so go test -race covers the locking of the model and the losses
+func TestScenariosConcurrently(t *testing.T) {
+	for _, file := range scenarioFiles(t) {
+		t.Run(filepath.Base(filepath.Dir(file)), func(t *testing.T) {
+			if err := runScenario(file, func(s *ThisService) func() {
+				return startConcurrentLoad(t, s)
+			}); err != nil {
+				t.Fatalf("%s:\n%v", file, err)
+			}
+		})
+	}
+}
+
+// startConcurrentLoad reads /api/topology.dot and /api/stats and replaces the profiles with the same ones
+// through updateModel until the returned function is called
+func startConcurrentLoad(t *testing.T, s *ThisService) func() {
+	handler := s.httpHandler()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for _, path := range []string{"/api/topology.dot", "/api/stats"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+				if recorder.Code != http.StatusOK {
+					t.Errorf("%s: status %d: %s", path, recorder.Code, recorder.Body)
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			done := make(chan error, 1)
+			if !s.updateModel(func() {
+				equipments := make([]types.EquipmentStruct, 0, len(s.equipmentFromEquipmentId))
+				for _, equipment := range s.equipmentFromEquipmentId {
+					equipments = append(equipments, equipment)
+				}
+				sort.Slice(equipments, func(i, j int) bool { return equipments[i].Id < equipments[j].Id })
+
+				s.applyEquipmentUpdate(nil, equipments)
+				done <- s.applyTopologyUpdate(nil, s.topologyProfile)
+			}) {
+				return
+			}
+			if err := <-done; err != nil {
+				t.Errorf("profile update: %v", err)
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		wg.Wait()
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"grid_losses/types"
+	"sync/atomic"
+)
+
+// InputStatsStruct counts the points dropped by ReceiveDataWorker
+type InputStatsStruct struct {
+	duplicates atomic.Uint64 // Repeats of the last value with the same timestamp
+	outOfOrder atomic.Uint64 // Values older than the last applied one
+	dropped    atomic.Uint64 // Duplicates and the rejected out-of-order values
+}
+
+// InputStats is the snapshot of InputStatsStruct
+type InputStats struct {
+	Duplicates uint64 `json:"duplicates" yaml:"duplicates"`
+	OutOfOrder uint64 `json:"out_of_order" yaml:"out_of_order"`
+	Dropped    uint64 `json:"dropped" yaml:"dropped"`
+}
+
+// InputStats from any goroutine
+func (s *ThisService) InputStats() InputStats {
+	return InputStats{
+		Duplicates: s.inputStats.duplicates.Load(),
+		OutOfOrder: s.inputStats.outOfOrder.Load(),
+		Dropped:    s.inputStats.dropped.Load(),
+	}
+}
+
+// isInOrder drops the repeats of the last applied value and the values older than it.
+// The values without a valid timestamp are always applied. Safe to call from ReceiveDataWorker and SwitchDataWorker:
+// pointStore is locked and the counters are atomic
+func (s *ThisService) isInOrder(point types.RtdbMessage) bool {
+	last, exists := s.pointStore.Get(point.Id)
+	if !exists || point.Timestamp.IsZero() || last.Timestamp.IsZero() {
+		return true
+	}
+
+	if point.Timestamp.Equal(last.Timestamp.Time) && point.Value == last.Value &&
+		point.Quality == last.Quality && point.Source == last.Source {
+		s.inputStats.duplicates.Add(1)
+		s.inputStats.dropped.Add(1)
+		return false
+	}
+
+	if !point.Timestamp.Before(last.Timestamp.Time) {
+		return true
+	}
+
+	s.inputStats.outOfOrder.Add(1)
+
+	if s.config.Rtdb.AcceptOutOfOrder {
+		llog.Logger.Debugf("Point %d is out of order: %s is older than %s", point.Id, point.Timestamp, last.Timestamp)
+		return true
+	}
+
+	s.inputStats.dropped.Add(1)
+	llog.Logger.Debugf("Point %d is out of order and dropped: %s is older than %s", point.Id, point.Timestamp, last.Timestamp)
+	return false
+}
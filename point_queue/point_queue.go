@@ -0,0 +1,281 @@
+//
+// The point_queue package is a bounded FIFO of RTDB messages with a policy applied when it is full
+//
+
+package point_queue
+
+import (
+	"errors"
+	"fmt"
+	"grid_losses/types"
+	"sync"
+)
+
+const DefaultWarnPercent = 80
+
+var ErrClosed = errors.New("queue is closed")
+
+// Policy applied to a new message when the queue is full
+type Policy int
+
+const (
+	PolicyBlock      Policy = iota // Wait for the free space
+	PolicyDropOldest               // Drop the oldest message
+	PolicyCoalesce                 // Replace the queued message of the same point, drop the oldest if there is none
+)
+
+// Select and execute commands are never dropped or replaced: when the oldest message is a command,
+// the push waits for the free space whatever the policy is
+
+func (p Policy) String() string {
+	switch p {
+	case PolicyBlock:
+		return "block"
+	case PolicyDropOldest:
+		return "drop_oldest"
+	case PolicyCoalesce:
+		return "coalesce"
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy from the configuration, block if empty
+func ParsePolicy(name string) (Policy, error) {
+	switch name {
+	case "", "block":
+		return PolicyBlock, nil
+	case "drop_oldest":
+		return PolicyDropOldest, nil
+	case "coalesce":
+		return PolicyCoalesce, nil
+	}
+	return PolicyBlock, fmt.Errorf("unknown queue policy: %s", name)
+}
+
+// WatermarkHandler is called when the queue length reaches the high watermark and when it falls to the half of it
+type WatermarkHandler func(length int, capacity int, isHigh bool)
+
+// Stats of the queue
+type Stats struct {
+	Policy        string `json:"policy"`
+	Length        int    `json:"length"`
+	Capacity      int    `json:"capacity"`
+	MaxLength     int    `json:"max_length"` // The deepest the queue has been
+	Pushed        uint64 `json:"pushed"`
+	Dropped       uint64 `json:"dropped"`   // Dropped oldest messages
+	Coalesced     uint64 `json:"coalesced"` // Messages replaced by a newer value of the same point
+	Blocked       uint64 `json:"blocked"`   // Pushes waited for the free space
+	HighWatermark int    `json:"high_watermark"`
+}
+
+type Queue struct {
+	policy           Policy
+	lock             sync.Mutex
+	notFull          *sync.Cond
+	ready            chan struct{}
+	items            []types.RtdbMessage
+	head             int
+	length           int
+	headSeq          uint64            // Sequence number of the message at the head
+	seqFromPointId   map[uint64]uint64 // Sequence number of the last queued message of the point
+	isClosed         bool
+	isHigh           bool
+	highWatermark    int
+	watermarkHandler WatermarkHandler
+	stats            Stats
+}
+
+// New queue of the capacity. The high watermark is warnPercent of the capacity, DefaultWarnPercent if 0
+func New(capacity int, policy Policy, warnPercent int) *Queue {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	if warnPercent <= 0 || warnPercent > 100 {
+		warnPercent = DefaultWarnPercent
+	}
+
+	q := &Queue{
+		policy:         policy,
+		ready:          make(chan struct{}, 1),
+		items:          make([]types.RtdbMessage, capacity),
+		seqFromPointId: make(map[uint64]uint64),
+		highWatermark:  max(1, capacity*warnPercent/100),
+	}
+	q.notFull = sync.NewCond(&q.lock)
+	return q
+}
+
+func (q *Queue) SetWatermarkHandler(handler WatermarkHandler) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.watermarkHandler = handler
+}
+
+// Push the message applying the policy if the queue is full. Returns ErrClosed after Close
+func (q *Queue) Push(msg types.RtdbMessage) error {
+	q.lock.Lock()
+
+	if q.isClosed {
+		q.lock.Unlock()
+		return ErrClosed
+	}
+
+	isCoalescing := q.policy == PolicyCoalesce && !isCommand(msg)
+
+	if q.length == len(q.items) {
+		if seq, exists := q.seqFromPointId[msg.Id]; exists && isCoalescing {
+			q.items[(q.head+int(seq-q.headSeq))%len(q.items)] = msg
+			q.stats.Pushed += 1
+			q.stats.Coalesced += 1
+			q.lock.Unlock()
+			return nil
+		}
+
+		if q.policy == PolicyBlock || isCommand(q.items[q.head]) {
+			if err := q.waitNotFull(); err != nil {
+				q.lock.Unlock()
+				return err
+			}
+		} else {
+			q.dropHead()
+		}
+	}
+
+	tail := (q.head + q.length) % len(q.items)
+	q.items[tail] = msg
+	if isCoalescing {
+		q.seqFromPointId[msg.Id] = q.headSeq + uint64(q.length)
+	}
+	q.length += 1
+	q.stats.Pushed += 1
+	q.stats.MaxLength = max(q.stats.MaxLength, q.length)
+
+	handler, length, isChanged := q.checkWatermark()
+	q.lock.Unlock()
+
+	q.signal()
+
+	if isChanged && handler != nil {
+		handler(length, len(q.items), true)
+	}
+	return nil
+}
+
+// Pop the oldest message. Returns false if the queue is empty
+func (q *Queue) Pop() (types.RtdbMessage, bool) {
+	q.lock.Lock()
+
+	if q.length == 0 {
+		q.lock.Unlock()
+		return types.RtdbMessage{}, false
+	}
+
+	msg := q.items[q.head]
+	q.removeHead()
+	q.notFull.Signal()
+
+	handler, length, isChanged := q.checkWatermark()
+	isEmpty := q.length == 0
+	q.lock.Unlock()
+
+	// Wake up the consumer for the rest of the messages
+	if !isEmpty {
+		q.signal()
+	}
+
+	if isChanged && handler != nil {
+		handler(length, len(q.items), false)
+	}
+	return msg, true
+}
+
+// Ready receives when the queue may have messages or is closed
+func (q *Queue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Close the queue: Push returns ErrClosed, the queued messages are still available to Pop
+func (q *Queue) Close() {
+	q.lock.Lock()
+	q.isClosed = true
+	q.notFull.Broadcast()
+	q.lock.Unlock()
+
+	q.signal()
+}
+
+// IsDone if the queue is closed and empty
+func (q *Queue) IsDone() bool {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.isClosed && q.length == 0
+}
+
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.length
+}
+
+func (q *Queue) Stats() Stats {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	stats := q.stats
+	stats.Policy = q.policy.String()
+	stats.Length = q.length
+	stats.Capacity = len(q.items)
+	stats.HighWatermark = q.highWatermark
+	return stats
+}
+
+func (q *Queue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// waitNotFull must be called with the lock held. Returns ErrClosed if the queue is closed while waiting
+func (q *Queue) waitNotFull() error {
+	q.stats.Blocked += 1
+	for q.length == len(q.items) && !q.isClosed {
+		q.notFull.Wait()
+	}
+	if q.isClosed {
+		return ErrClosed
+	}
+	return nil
+}
+
+func isCommand(msg types.RtdbMessage) bool {
+	return msg.Select != 0 || msg.Execute != 0
+}
+
+func (q *Queue) dropHead() {
+	q.removeHead()
+	q.stats.Dropped += 1
+}
+
+func (q *Queue) removeHead() {
+	if seq, exists := q.seqFromPointId[q.items[q.head].Id]; exists && seq == q.headSeq {
+		delete(q.seqFromPointId, q.items[q.head].Id)
+	}
+	q.items[q.head] = types.RtdbMessage{}
+	q.head = (q.head + 1) % len(q.items)
+	q.headSeq += 1
+	q.length -= 1
+}
+
+// checkWatermark returns the handler to call if the length crossed the high watermark or fell to the half of it
+func (q *Queue) checkWatermark() (WatermarkHandler, int, bool) {
+	if !q.isHigh && q.length >= q.highWatermark {
+		q.isHigh = true
+		return q.watermarkHandler, q.length, true
+	}
+	if q.isHigh && q.length <= q.highWatermark/2 {
+		q.isHigh = false
+		return q.watermarkHandler, q.length, true
+	}
+	return nil, 0, false
+}
@@ -0,0 +1,90 @@
+package point_queue
+
+import (
+	"grid_losses/types"
+	"testing"
+	"time"
+)
+
+func TestPushNeverDropsCommands(t *testing.T) {
+	for _, policy := range []Policy{PolicyDropOldest, PolicyCoalesce} {
+		t.Run(policy.String(), func(t *testing.T) {
+			q := New(2, policy, 0)
+
+			if err := q.Push(types.RtdbMessage{Id: 1, Select: 1}); err != nil {
+				t.Fatal(err)
+			}
+			if err := q.Push(types.RtdbMessage{Id: 2, Value: 1}); err != nil {
+				t.Fatal(err)
+			}
+
+			pushed := make(chan error)
+			go func() {
+				pushed <- q.Push(types.RtdbMessage{Id: 2, Value: 2})
+			}()
+
+			if policy == PolicyCoalesce {
+				// The value of the same point is replaced without waiting
+				if err := <-pushed; err != nil {
+					t.Fatal(err)
+				}
+				go func() {
+					pushed <- q.Push(types.RtdbMessage{Id: 3, Value: 3})
+				}()
+			}
+
+			select {
+			case err := <-pushed:
+				t.Fatalf("push did not wait for the command at the head: %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			if msg, _ := q.Pop(); msg.Id != 1 || msg.Select != 1 {
+				t.Fatalf("expected the select of point 1, got %+v", msg)
+			}
+			if err := <-pushed; err != nil {
+				t.Fatal(err)
+			}
+			if stats := q.Stats(); stats.Dropped != 0 || stats.Blocked != 1 {
+				t.Fatalf("expected no drops and one blocked push, got %+v", stats)
+			}
+		})
+	}
+}
+
+func TestPushDropsOldestValue(t *testing.T) {
+	q := New(2, PolicyDropOldest, 0)
+
+	for _, msg := range []types.RtdbMessage{{Id: 1}, {Id: 2, Execute: 1}, {Id: 3}} {
+		if err := q.Push(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if msg, _ := q.Pop(); msg.Id != 2 || msg.Execute != 1 {
+		t.Fatalf("expected the execute of point 2, got %+v", msg)
+	}
+	if stats := q.Stats(); stats.Dropped != 1 {
+		t.Fatalf("expected one dropped value, got %+v", stats)
+	}
+}
+
+func TestCloseReleasesBlockedPush(t *testing.T) {
+	q := New(1, PolicyDropOldest, 0)
+
+	if err := q.Push(types.RtdbMessage{Id: 1, Select: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	pushed := make(chan error)
+	go func() {
+		pushed <- q.Push(types.RtdbMessage{Id: 2})
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+
+	if err := <-pushed; err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"grid_losses/types"
+	"sync"
+)
+
+// PointStoreStruct keeps the last value of each point. The switch states are set by SwitchDataWorker,
+// the other points by ReceiveDataWorker
+type PointStoreStruct struct {
+	lock             sync.RWMutex
+	valueFromPointId map[uint64]types.RtdbMessage
+}
+
+// NewPointStore with the room for the points
+func NewPointStore(size int) *PointStoreStruct {
+	return &PointStoreStruct{valueFromPointId: make(map[uint64]types.RtdbMessage, size)}
+}
+
+// CreatePointStore for the points used by the topology and the losses calculation keeping the received values
+func (s *ThisService) CreatePointStore() {
+	pointIds := s.usedPointIds()
+	store := NewPointStore(len(pointIds))
+
+	for pointId := range pointIds {
+		if point, exists := s.pointStore.Get(pointId); exists {
+			store.Set(point)
+		}
+	}
+
+	s.pointStore = store
+}
+
+// Get the last value of the point
+func (p *PointStoreStruct) Get(pointId uint64) (types.RtdbMessage, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	point, exists := p.valueFromPointId[pointId]
+	return point, exists
+}
+
+// Value of the point or 0 if the point is not received yet
+func (p *PointStoreStruct) Value(pointId uint64) float64 {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return float64(p.valueFromPointId[pointId].Value)
+}
+
+// Set the last value of the point
+func (p *PointStoreStruct) Set(point types.RtdbMessage) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.valueFromPointId[point.Id] = point
+}
+
+// Range calls f for each point
+func (p *PointStoreStruct) Range(f func(point types.RtdbMessage)) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	for _, point := range p.valueFromPointId {
+		f(point)
+	}
+}
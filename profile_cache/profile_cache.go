@@ -0,0 +1,247 @@
+//
+// The profile_cache package keeps versioned snapshots of the profiles loaded from the configuration API
+//
+
+package profile_cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const SnapshotsDir = "snapshots"
+const SnapshotInfoFile = "snapshot.json"
+const SnapshotIdLayout = "20060102T150405.000Z"
+
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+var ErrInvalidSnapshotId = errors.New("invalid snapshot id")
+
+type FileInfo struct {
+	SourceUrl    string `json:"source_url"` // URL of the profile on the configuration API host
+	ChecksumIEEE uint32 `json:"crc32"`
+	Size         int    `json:"size"`
+}
+
+type SnapshotInfo struct {
+	Id        string              `json:"id"`
+	Timestamp time.Time           `json:"timestamp"`
+	Files     map[string]FileInfo `json:"files"`
+}
+
+// Snapshot is a set of profiles loaded at the same time
+type Snapshot struct {
+	SnapshotInfo
+	data map[string][]byte
+}
+
+type Store struct {
+	Dir       string
+	Retention int
+}
+
+func New(dir string, retention int) *Store {
+	return &Store{Dir: dir, Retention: retention}
+}
+
+// NewSnapshot creates an empty snapshot with the id from the current time
+func NewSnapshot() *Snapshot {
+	now := time.Now().UTC()
+	return &Snapshot{
+		SnapshotInfo: SnapshotInfo{
+			Id:        now.Format(SnapshotIdLayout),
+			Timestamp: now,
+			Files:     make(map[string]FileInfo),
+		},
+		data: make(map[string][]byte),
+	}
+}
+
+// Add profile data loaded from sourceUrl
+func (s *Snapshot) Add(name string, sourceUrl string, data []byte) {
+	s.Files[name] = FileInfo{
+		SourceUrl:    sourceUrl,
+		ChecksumIEEE: crc32.ChecksumIEEE(data),
+		Size:         len(data),
+	}
+	s.data[name] = data
+}
+
+// Has all the named profiles
+func (s *Snapshot) Has(names ...string) bool {
+	for _, name := range names {
+		if _, exists := s.data[name]; !exists {
+			return false
+		}
+	}
+	return true
+}
+
+// checkSnapshotId rejects the ids which are not a single directory name inside the cache
+func checkSnapshotId(id string) error {
+	if id == "" || id == "." || strings.Contains(id, "..") || strings.ContainsAny(id, `/\`) || filepath.IsAbs(id) {
+		return fmt.Errorf("%w: %q", ErrInvalidSnapshotId, id)
+	}
+	return nil
+}
+
+func (c *Store) snapshotPath(id string) string {
+	return filepath.Join(c.Dir, SnapshotsDir, id)
+}
+
+// Save the snapshot if it differs from the latest one and remove the snapshots exceeding the retention.
+// Returns true if the snapshot has been written
+func (c *Store) Save(snapshot *Snapshot) (bool, error) {
+	if len(snapshot.data) == 0 {
+		return false, nil
+	}
+
+	if latest, err := c.Latest(); err == nil && sameFiles(latest.Files, snapshot.Files) {
+		return false, nil
+	}
+
+	path := c.snapshotPath(snapshot.Id)
+
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return false, err
+	}
+
+	for name, data := range snapshot.data {
+		if err := os.WriteFile(filepath.Join(path, name), data, 0644); err != nil {
+			return false, err
+		}
+	}
+
+	info, err := json.MarshalIndent(snapshot.SnapshotInfo, "", "  ")
+	if err != nil {
+		return false, err
+	}
+
+	if err = os.WriteFile(filepath.Join(path, SnapshotInfoFile), info, 0644); err != nil {
+		return false, err
+	}
+
+	return true, c.prune()
+}
+
+// List of snapshots sorted from the oldest to the latest
+func (c *Store) List() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(c.Dir, SnapshotsDir))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	snapshots := make([]SnapshotInfo, 0, len(entries))
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := c.Info(entry.Name())
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, info)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Timestamp.Before(snapshots[j].Timestamp)
+	})
+
+	return snapshots, nil
+}
+
+// Info of the snapshot by id
+func (c *Store) Info(id string) (SnapshotInfo, error) {
+	var info SnapshotInfo
+
+	if err := checkSnapshotId(id); err != nil {
+		return info, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(c.snapshotPath(id), SnapshotInfoFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return info, fmt.Errorf("%w: %s", ErrSnapshotNotFound, id)
+		}
+		return info, err
+	}
+
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// Latest snapshot info
+func (c *Store) Latest() (SnapshotInfo, error) {
+	snapshots, err := c.List()
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	if len(snapshots) == 0 {
+		return SnapshotInfo{}, ErrSnapshotNotFound
+	}
+	return snapshots[len(snapshots)-1], nil
+}
+
+// Load profile data from the snapshot. The "latest" id means the most recent snapshot
+func (c *Store) Load(id string, name string) ([]byte, error) {
+	if id == "latest" {
+		latest, err := c.Latest()
+		if err != nil {
+			return nil, err
+		}
+		id = latest.Id
+	}
+
+	info, err := c.Info(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, exists := info.Files[name]; !exists {
+		return nil, fmt.Errorf("snapshot %s has no %s", id, name)
+	}
+
+	return os.ReadFile(filepath.Join(c.snapshotPath(id), name))
+}
+
+// prune removes the oldest snapshots exceeding the retention. Retention <= 0 keeps all snapshots
+func (c *Store) prune() error {
+	if c.Retention <= 0 {
+		return nil
+	}
+
+	snapshots, err := c.List()
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(snapshots)-c.Retention; i++ {
+		if err = os.RemoveAll(c.snapshotPath(snapshots[i].Id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sameFiles(a map[string]FileInfo, b map[string]FileInfo) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, fileA := range a {
+		fileB, exists := b[name]
+		if !exists || fileA.ChecksumIEEE != fileB.ChecksumIEEE || fileA.Size != fileB.Size {
+			return false
+		}
+	}
+	return true
+}
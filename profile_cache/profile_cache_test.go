@@ -0,0 +1,57 @@
+package profile_cache
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSaveAndLoadLatest(t *testing.T) {
+	store := New(t.TempDir(), 0)
+
+	snapshot := NewSnapshot()
+	snapshot.Add("topology.json", "https://host/api/topology/graph", []byte(`{"node": []}`))
+	snapshot.Add("equipment.json", "https://host/api/equipment", []byte(`[]`))
+
+	if !snapshot.Has("topology.json", "equipment.json") || snapshot.Has("topology.json", "cim.xml") {
+		t.Fatal("Has does not match the added profiles")
+	}
+
+	if saved, err := store.Save(snapshot); err != nil || !saved {
+		t.Fatalf("saved %v: %v", saved, err)
+	}
+
+	// The same profiles are not saved again
+	again := NewSnapshot()
+	again.Id += "1"
+	again.Add("topology.json", "https://host/api/topology/graph", []byte(`{"node": []}`))
+	again.Add("equipment.json", "https://host/api/equipment", []byte(`[]`))
+	if saved, err := store.Save(again); err != nil || saved {
+		t.Fatalf("unchanged snapshot saved %v: %v", saved, err)
+	}
+
+	data, err := store.Load("latest", "equipment.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" {
+		t.Errorf("loaded %s", data)
+	}
+
+	info, err := store.Info(snapshot.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url := info.Files["topology.json"].SourceUrl; url != "https://host/api/topology/graph" {
+		t.Errorf("source url %s", url)
+	}
+}
+
+func TestInvalidSnapshotId(t *testing.T) {
+	store := New(t.TempDir(), 0)
+
+	for _, id := range []string{"", ".", "..", "../etc", "a/b", `a\b`, "/tmp"} {
+		if _, err := store.Load(id, "topology.json"); !errors.Is(err, ErrInvalidSnapshotId) {
+			t.Errorf("id %q: %v", id, err)
+		}
+	}
+}
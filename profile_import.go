@@ -0,0 +1,271 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/PVKonovalov/topogrid"
+	"grid_losses/cim"
+	"grid_losses/llog"
+	"grid_losses/profile_cache"
+	"grid_losses/types"
+	"os"
+)
+
+// EquipmentTypeTransformer is not known by topogrid, so a transformer is a node connecting its windings
+const EquipmentTypeTransformer = 7
+
+// CIM classes mapped to the topology
+var cimSwitchClasses = map[string]int{
+	"Breaker":         topogrid.TypeCircuitBreaker,
+	"Recloser":        topogrid.TypeCircuitBreaker,
+	"Disconnector":    topogrid.TypeDisconnectSwitch,
+	"LoadBreakSwitch": topogrid.TypeDisconnectSwitch,
+	"Fuse":            topogrid.TypeDisconnectSwitch,
+	"Switch":          topogrid.TypeDisconnectSwitch,
+}
+
+var cimNodeClasses = map[string]int{
+	"ACLineSegment":            topogrid.TypeLine,
+	"PowerTransformer":         EquipmentTypeTransformer,
+	"EnergySource":             topogrid.TypePower,
+	"ExternalNetworkInjection": topogrid.TypePower,
+	"EnergyConsumer":           topogrid.TypeConsumer,
+	"ConformLoad":              topogrid.TypeConsumer,
+	"NonConformLoad":           topogrid.TypeConsumer,
+}
+
+var equipmentTypeNames = map[int]string{
+	topogrid.TypeCircuitBreaker:   "Circuit breaker",
+	topogrid.TypeDisconnectSwitch: "Disconnect switch",
+	topogrid.TypePower:            "Power",
+	topogrid.TypeConsumer:         "Consumer",
+	topogrid.TypeLine:             "Line",
+	EquipmentTypeTransformer:      "Transformer",
+}
+
+// LoadTopologyProfileFromFile loading topologyProfile from JSON file in the configuration API format
+func (s *ThisService) LoadTopologyProfileFromFile(path string) error {
+	llog.Logger.Infof("Loading topology profile from file (%s)", path)
+
+	profileData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return s.SetTopologyData(profileData)
+}
+
+// LoadEquipmentProfileFromFile loading equipment from JSON file in the configuration API format
+func (s *ThisService) LoadEquipmentProfileFromFile(path string) error {
+	llog.Logger.Infof("Loading equipment profile from file (%s)", path)
+
+	profileData, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	return s.SetEquipmentData(profileData)
+}
+
+// LoadProfilesFromCacheSnapshot loading topology and equipment from the versioned cache snapshot
+func (s *ThisService) LoadProfilesFromCacheSnapshot(store *profile_cache.Store, id string) error {
+	llog.Logger.Infof("Loading profiles from cache snapshot %s (%s)", id, store.Dir)
+
+	topologyData, err := store.Load(id, CacheTopologyFile)
+	if err != nil {
+		return err
+	}
+
+	if err = s.SetTopologyData(topologyData); err != nil {
+		return err
+	}
+
+	equipmentData, err := store.Load(id, CacheEquipmentFile)
+	if err != nil {
+		return err
+	}
+
+	return s.SetEquipmentData(equipmentData)
+}
+
+// LoadProfilesFromCimFile loading topology and equipment from IEC 61970 CIM (CGMES) RDF/XML file
+func (s *ThisService) LoadProfilesFromCimFile(path string) error {
+	llog.Logger.Infof("Loading CIM model from file (%s)", path)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	model, err := cim.Parse(f)
+	if err != nil {
+		return err
+	}
+
+	var equipments []types.EquipmentStruct
+
+	if s.topologyProfile, equipments, err = ProfilesFromCimModel(model); err != nil {
+		return err
+	}
+
+	for _, _equipment := range equipments {
+		s.equipmentFromEquipmentId[_equipment.Id] = _equipment
+	}
+
+	llog.Logger.Infof("CIM model: %d nodes, %d edges, %d equipment",
+		len(s.topologyProfile.Node), len(s.topologyProfile.Edge), len(equipments))
+
+	return nil
+}
+
+// ProfilesFromCimModel maps CIM conducting equipment to the topology and equipment profiles.
+// ConnectivityNode becomes a node (TopologicalNode if the terminals do not refer to the connectivity nodes), switches
+// become edges between the nodes of their terminals, lines, transformers, sources and consumers become nodes connected
+// to the nodes of their terminals. The nodes not referred by any terminal are skipped.
+// Integer identifiers are assigned in document order.
+func ProfilesFromCimModel(model *cim.Model) (*types.TopologyStruct, []types.EquipmentStruct, error) {
+	topology := &types.TopologyStruct{}
+	var equipments []types.EquipmentStruct
+
+	nodeIdFromCimId := make(map[string]int)
+	voltageClassIdFromVoltage := make(map[float64]int)
+
+	nodeIdFromCim := func(cimId string) int {
+		if nodeId, exists := nodeIdFromCimId[cimId]; exists {
+			return nodeId
+		}
+		nodeId := len(topology.Node) + 1
+		nodeIdFromCimId[cimId] = nodeId
+		topology.Node = append(topology.Node, types.NodeStruct{Id: nodeId})
+		return nodeId
+	}
+
+	nodeClass := cimNodeClassOf(model)
+	nodeProperty := "Terminal." + nodeClass
+
+	nodeIdOf := func(terminal *cim.Object) int {
+		cimId := terminal.Get(nodeProperty)
+		if cimId == "" {
+			return 0
+		}
+		return nodeIdFromCim(cimId)
+	}
+
+	addEquipment := func(object *cim.Object, typeId int) types.EquipmentStruct {
+		equipment := types.EquipmentStruct{
+			Id:            len(equipments) + 1,
+			Name:          object.Name(),
+			TypeId:        typeId,
+			EquipmentType: equipmentTypeNames[typeId],
+		}
+
+		if voltage := model.NominalVoltage(object); voltage != 0 {
+			if _, exists := voltageClassIdFromVoltage[voltage]; !exists {
+				voltageClassIdFromVoltage[voltage] = len(voltageClassIdFromVoltage) + 1
+			}
+			equipment.VoltageClassId = voltageClassIdFromVoltage[voltage]
+			equipment.EquipmentVoltageClass = fmt.Sprintf("%g kV", voltage)
+		}
+
+		equipments = append(equipments, equipment)
+		return equipment
+	}
+
+	addEdge := func(terminal1 int, terminal2 int, state int, equipment types.EquipmentStruct) {
+		topology.Edge = append(topology.Edge, types.EdgeStruct{
+			Id:                      len(topology.Edge) + 1,
+			Terminal1:               terminal1,
+			Terminal2:               terminal2,
+			StateNormal:             state,
+			EquipmentId:             equipment.Id,
+			EquipmentName:           equipment.Name,
+			EquipmentType:           equipment.EquipmentType,
+			EquipmentTypeId:         equipment.TypeId,
+			EquipmentVoltageClassId: equipment.VoltageClassId,
+		})
+	}
+
+	isReferredNode := make(map[string]bool)
+	for _, terminal := range model.ObjectsByClass("Terminal") {
+		isReferredNode[terminal.Get(nodeProperty)] = true
+	}
+
+	for _, object := range model.ObjectsByClass(nodeClass) {
+		if isReferredNode[object.Id] {
+			nodeIdFromCim(object.Id)
+		}
+	}
+
+	for _, object := range model.ObjectsByClass(keysOf(cimSwitchClasses)...) {
+		terminals := model.TerminalsOf(object.Id)
+		if len(terminals) != 2 {
+			llog.Logger.Warnf("CIM: %s %s has %d terminals, skipped", object.Class, object.Name(), len(terminals))
+			continue
+		}
+
+		node1, node2 := nodeIdOf(terminals[0]), nodeIdOf(terminals[1])
+		if node1 == 0 || node2 == 0 {
+			llog.Logger.Warnf("CIM: %s %s is not connected, skipped", object.Class, object.Name())
+			continue
+		}
+
+		state := topogrid.SwitchStateClose
+		if object.Get("Switch.normalOpen") == "true" {
+			state = topogrid.SwitchStateOpen
+		}
+
+		addEdge(node1, node2, state, addEquipment(object, cimSwitchClasses[object.Class]))
+	}
+
+	for _, object := range model.ObjectsByClass(keysOf(cimNodeClasses)...) {
+		terminals := model.TerminalsOf(object.Id)
+		if len(terminals) == 0 {
+			llog.Logger.Warnf("CIM: %s %s has no terminals, skipped", object.Class, object.Name())
+			continue
+		}
+
+		equipment := addEquipment(object, cimNodeClasses[object.Class])
+
+		nodeId := len(topology.Node) + 1
+		topology.Node = append(topology.Node, types.NodeStruct{
+			Id:                      nodeId,
+			EquipmentId:             equipment.Id,
+			EquipmentName:           equipment.Name,
+			EquipmentTypeId:         equipment.TypeId,
+			EquipmentVoltageClassId: equipment.VoltageClassId,
+		})
+
+		for _, terminal := range terminals {
+			if terminalNodeId := nodeIdOf(terminal); terminalNodeId != 0 {
+				addEdge(nodeId, terminalNodeId, topogrid.SwitchStateClose, types.EquipmentStruct{})
+			}
+		}
+	}
+
+	if len(topology.Edge) == 0 {
+		return nil, nil, errors.New("cim: model has no connected equipment")
+	}
+
+	return topology, equipments, nil
+}
+
+// cimNodeClassOf returns ConnectivityNode if the terminals refer to it, otherwise TopologicalNode
+// of the bus-branch models. Both are never mixed, a connectivity node is a part of a topological one
+func cimNodeClassOf(model *cim.Model) string {
+	for _, terminal := range model.ObjectsByClass("Terminal") {
+		if terminal.Get("Terminal.ConnectivityNode") != "" {
+			return "ConnectivityNode"
+		}
+	}
+	return "TopologicalNode"
+}
+
+func keysOf(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
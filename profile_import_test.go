@@ -0,0 +1,107 @@
+package main
+
+import (
+	"github.com/PVKonovalov/topogrid"
+	"grid_losses/cim"
+	"grid_losses/types"
+	"os"
+	"testing"
+)
+
+func profilesFromCimFile(t *testing.T, path string) (*types.TopologyStruct, []types.EquipmentStruct) {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	model, err := cim.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topology, equipments, err := ProfilesFromCimModel(model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return topology, equipments
+}
+
+func TestProfilesFromCimConnectivityNodes(t *testing.T) {
+	topology, equipments := profilesFromCimFile(t, "cim/testdata/radial.xml")
+
+	// CN 1..3 are the nodes 1..3: the topological nodes and the not connected CN 9 are skipped,
+	// then the nodes of the source, the line and the consumer
+	if len(topology.Node) != 6 {
+		t.Fatalf("%d nodes, expected 6: %+v", len(topology.Node), topology.Node)
+	}
+	for idx, node := range topology.Node {
+		if node.Id != idx+1 {
+			t.Errorf("node %d has id %d", idx, node.Id)
+		}
+	}
+
+	// The switches first, then the other equipment in document order; DS 1 with one terminal is skipped
+	expectedEquipment := []struct {
+		name   string
+		typeId int
+	}{
+		{"BR 1", topogrid.TypeCircuitBreaker},
+		{"Source", topogrid.TypePower},
+		{"Line 1", topogrid.TypeLine},
+		{"_LOAD1", topogrid.TypeConsumer},
+	}
+	if len(equipments) != len(expectedEquipment) {
+		t.Fatalf("%d equipment, expected %d: %+v", len(equipments), len(expectedEquipment), equipments)
+	}
+	for idx, expected := range expectedEquipment {
+		equipment := equipments[idx]
+		if equipment.Id != idx+1 || equipment.Name != expected.name || equipment.TypeId != expected.typeId {
+			t.Errorf("equipment %d: %+v, expected %s of type %d", idx+1, equipment, expected.name, expected.typeId)
+		}
+	}
+	if equipments[0].VoltageClassId != 1 || equipments[0].EquipmentVoltageClass != "10 kV" {
+		t.Errorf("voltage class of BR 1: %d %s", equipments[0].VoltageClassId, equipments[0].EquipmentVoltageClass)
+	}
+
+	expectedEdges := []struct {
+		terminal1   int
+		terminal2   int
+		state       int
+		equipmentId int
+	}{
+		{1, 2, topogrid.SwitchStateOpen, 1},
+		{4, 1, topogrid.SwitchStateClose, 0},
+		{5, 2, topogrid.SwitchStateClose, 0},
+		{5, 3, topogrid.SwitchStateClose, 0},
+		{6, 3, topogrid.SwitchStateClose, 0},
+	}
+	if len(topology.Edge) != len(expectedEdges) {
+		t.Fatalf("%d edges, expected %d: %+v", len(topology.Edge), len(expectedEdges), topology.Edge)
+	}
+	for idx, expected := range expectedEdges {
+		edge := topology.Edge[idx]
+		if edge.Id != idx+1 || edge.Terminal1 != expected.terminal1 || edge.Terminal2 != expected.terminal2 ||
+			edge.StateNormal != expected.state || edge.EquipmentId != expected.equipmentId {
+			t.Errorf("edge %d: %+v, expected %+v", idx+1, edge, expected)
+		}
+	}
+}
+
+func TestProfilesFromCimTopologicalNodes(t *testing.T) {
+	topology, equipments := profilesFromCimFile(t, "cim/testdata/bus_branch.xml")
+
+	if len(topology.Node) != 3 || len(equipments) != 2 || len(topology.Edge) != 2 {
+		t.Fatalf("%d nodes, %d equipment, %d edges, expected 3, 2, 2", len(topology.Node), len(equipments), len(topology.Edge))
+	}
+
+	breaker := topology.Edge[0]
+	if breaker.Terminal1 != 1 || breaker.Terminal2 != 2 || breaker.StateNormal != topogrid.SwitchStateClose {
+		t.Errorf("breaker edge %+v, expected closed between TN 1 and TN 2", breaker)
+	}
+	if grid := topology.Edge[1]; grid.Terminal1 != 3 || grid.Terminal2 != 1 {
+		t.Errorf("grid edge %+v, expected between the grid node and TN 1", grid)
+	}
+}
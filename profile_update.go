@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/PVKonovalov/localcache"
+	"grid_losses/llog"
+	"grid_losses/types"
+	"grid_losses/webapi"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ProfileUpdaterStruct keeps the state of the profile updates from the host the profiles were loaded from.
+// Only the profiles loaded from the API are updated, the profiles from the files are kept
+type ProfileUpdaterStruct struct {
+	host                        string
+	topology                    webapi.Validators
+	equipment                   webapi.Validators
+	isTopologyFromApi           bool
+	isEquipmentFromApi          bool
+	isTopologyChangesSupported  bool
+	isEquipmentChangesSupported bool
+}
+
+// ProfileUpdateWorker periodically pulls the profile changes until the service is shutting down
+func (s *ThisService) ProfileUpdateWorker(period time.Duration) {
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.shutdown:
+			return
+		case <-ticker.C:
+			if err := s.UpdateProfiles(); err != nil {
+				llog.Logger.Warnf("Failed to update profiles from %s: %v", s.profileUpdater.host, err)
+			}
+		}
+	}
+}
+
+// UpdateProfiles pulls the incremental changes (or the full profile if it was modified and the server
+// does not support the incremental changes) and passes them to ReceiveDataWorker for the in-place model update
+func (s *ThisService) UpdateProfiles() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*ApiTimeoutSec)
+	defer cancel()
+
+	client := webapi.NewClient(s.profileLoader.Connection(s.profileUpdater.host), s.config.GridLosses.ApiPrefix)
+
+	topologyChanges, topology, topologyValidators, err := s.fetchTopologyUpdate(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	equipmentChanges, equipments, equipmentValidators, err := s.fetchEquipmentUpdate(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	isTopologyChanged := topology != nil || (topologyChanges != nil && !topologyChanges.IsEmpty())
+	isEquipmentChanged := equipments != nil || (equipmentChanges != nil && !equipmentChanges.IsEmpty())
+
+	if isTopologyChanged || isEquipmentChanged {
+		done := make(chan error)
+
+		isAccepted := s.updateModel(func() {
+			var err error
+			if isEquipmentChanged {
+				s.applyEquipmentUpdate(equipmentChanges, equipments)
+			}
+			if isTopologyChanged {
+				err = s.applyTopologyUpdate(topologyChanges, topology)
+			}
+			done <- err
+		})
+
+		if !isAccepted {
+			return errors.New("the service is shutting down")
+		}
+
+		if err = <-done; err != nil {
+			return err
+		}
+	}
+
+	// The changes are applied, the next update continues from them
+	s.profileUpdater.topology = topologyValidators
+	s.profileUpdater.equipment = equipmentValidators
+	return nil
+}
+
+// fetchTopologyUpdate returns the changes or the modified topology with the validators to store once they are applied
+func (s *ThisService) fetchTopologyUpdate(ctx context.Context, client *webapi.Client) (*types.TopologyChangesStruct, *types.TopologyStruct, webapi.Validators, error) {
+	updater := s.profileUpdater
+	validators := updater.topology
+
+	if !updater.isTopologyFromApi {
+		return nil, nil, validators, nil
+	}
+
+	if updater.isTopologyChangesSupported && validators.Revision != 0 {
+		changes, err := client.GetTopologyChanges(ctx, validators.Revision)
+		if err == nil {
+			// The profile changed in place is not the full profile of the checksum any more
+			validators.Revision = changes.Revision
+			validators.ChecksumIEEE = 0
+			return changes, nil, validators, nil
+		}
+		if !errors.Is(err, webapi.ErrNotFound) {
+			return nil, nil, validators, err
+		}
+		llog.Logger.Infof("Incremental topology changes are not supported by %s", updater.host)
+		updater.isTopologyChangesSupported = false
+	}
+
+	topology, received, err := client.GetTopologyIfModified(ctx, validators)
+	if errors.Is(err, webapi.ErrNotModified) {
+		return nil, nil, validators, nil
+	}
+	return nil, topology, received, err
+}
+
+// fetchEquipmentUpdate returns the changes or the modified equipment with the validators to store once they are applied
+func (s *ThisService) fetchEquipmentUpdate(ctx context.Context, client *webapi.Client) (*types.EquipmentChangesStruct, []types.EquipmentStruct, webapi.Validators, error) {
+	updater := s.profileUpdater
+	validators := updater.equipment
+
+	if !updater.isEquipmentFromApi {
+		return nil, nil, validators, nil
+	}
+
+	if updater.isEquipmentChangesSupported && validators.Revision != 0 {
+		changes, err := client.GetEquipmentChanges(ctx, validators.Revision)
+		if err == nil {
+			// The profile changed in place is not the full profile of the checksum any more
+			validators.Revision = changes.Revision
+			validators.ChecksumIEEE = 0
+			return changes, nil, validators, nil
+		}
+		if !errors.Is(err, webapi.ErrNotFound) {
+			return nil, nil, validators, err
+		}
+		llog.Logger.Infof("Incremental equipment changes are not supported by %s", updater.host)
+		updater.isEquipmentChangesSupported = false
+	}
+
+	equipments, received, err := client.GetEquipmentIfModified(ctx, validators)
+	if errors.Is(err, webapi.ErrNotModified) {
+		return nil, nil, validators, nil
+	}
+	return nil, equipments, received, err
+}
+
+// applyEquipmentUpdate must be called from ReceiveDataWorker
+func (s *ThisService) applyEquipmentUpdate(changes *types.EquipmentChangesStruct, equipments []types.EquipmentStruct) {
+	s.modelLock.Lock()
+
+	if equipments != nil {
+		llog.Logger.Infof("Equipment profile replaced: %d equipment", len(equipments))
+		s.equipmentFromEquipmentId = make(map[int]types.EquipmentStruct, len(equipments))
+		for _, _equipment := range equipments {
+			s.equipmentFromEquipmentId[_equipment.Id] = _equipment
+		}
+	} else {
+		llog.Logger.Infof("Equipment profile updated to revision %d: %d changed, %d deleted",
+			changes.Revision, len(changes.Changed), len(changes.Deleted))
+		for _, _equipment := range changes.Changed {
+			s.equipmentFromEquipmentId[_equipment.Id] = _equipment
+		}
+		for _, id := range changes.Deleted {
+			delete(s.equipmentFromEquipmentId, id)
+		}
+	}
+
+	s.pointNameFromPointId = make(map[uint64]string)
+	s.resourceStructFromPointId = make(map[uint64]ResourceStruct)
+	s.pointFromEquipmentIdAndResourceTypeId = make(map[int]map[int]uint64)
+	s.equipmentIdArrayFromResourceTypeId = make(map[int][]int)
+	s.equipmentIdFromControlPointId = make(map[uint64]int)
+	s.numberOfCBCheckingLink = 0
+
+	s.CreateInternalParametersFromProfiles()
+
+	s.modelLock.Unlock()
+
+	s.UpdateSubscriptions()
+
+	equipmentArray := make([]types.EquipmentStruct, 0, len(s.equipmentFromEquipmentId))
+	for _, _equipment := range s.equipmentFromEquipmentId {
+		equipmentArray = append(equipmentArray, _equipment)
+	}
+	sort.Slice(equipmentArray, func(i, j int) bool { return equipmentArray[i].Id < equipmentArray[j].Id })
+
+	s.saveToCache(CacheEquipmentFile, equipmentArray)
+}
+
+// applyTopologyUpdate must be called from ReceiveDataWorker. The topology is rebuilt
+// and the last received switch states are applied to it
+func (s *ThisService) applyTopologyUpdate(changes *types.TopologyChangesStruct, topology *types.TopologyStruct) error {
+	topologyProfile := topology
+
+	if topologyProfile == nil {
+		profile := *s.topologyProfile
+		profile.ApplyChanges(changes)
+		topologyProfile = &profile
+		llog.Logger.Infof("Topology profile updated to revision %d: %d nodes, %d edges changed, %d nodes, %d edges deleted",
+			changes.Revision, len(changes.Node), len(changes.Edge), len(changes.DeletedNode), len(changes.DeletedEdge))
+	} else {
+		llog.Logger.Infof("Topology profile replaced: %d nodes, %d edges", len(topology.Node), len(topology.Edge))
+	}
+
+	topologyFlisr, err := NewTopologyGrid(topologyProfile)
+	if err != nil {
+		return err
+	}
+
+	topologyGrid, err := NewTopologyGrid(topologyProfile)
+	if err != nil {
+		return err
+	}
+
+	// The switch states are replayed under the lock, so SwitchDataWorker applies the later ones to the new topology
+	s.modelLock.Lock()
+
+	s.pointStore.Range(func(point types.RtdbMessage) {
+		if resource, exists := s.resourceStructFromPointId[point.Id]; exists && resource.resourceTypeId == ResourceTypeState {
+			_ = topologyGrid.SetSwitchStateByEquipmentId(resource.equipmentId, int(point.Value))
+		}
+	})
+
+	topologyGrid.SetEquipmentElectricalState()
+
+	s.topologyProfile = topologyProfile
+	s.topologyFlisr = topologyFlisr
+	s.topologyGrid = topologyGrid
+	s.modelLock.Unlock()
+
+	s.assignBranchShards()
+	s.CalculateAllBranchLosses()
+
+	s.saveToCache(CacheTopologyFile, topologyProfile)
+
+	return nil
+}
+
+// saveToCache the updated profile, so the next start from the cache gets the latest model
+func (s *ThisService) saveToCache(file string, profile interface{}) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		llog.Logger.Errorf("Failed to marshal %s: %v", file, err)
+		return
+	}
+
+	cache := localcache.New(filepath.Join(s.cachePath, file))
+	if err = cache.Save(data); err != nil {
+		llog.Logger.Errorf("Failed to write to local cache (%s)", cache.PathToCache)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"grid_losses/point_queue"
+	"grid_losses/types"
+)
+
+// CreateQueues of the input, the switch and the output points with the configured overflow policies.
+// The switch states are never dropped
+func (s *ThisService) CreateQueues() error {
+	inputPolicy, err := point_queue.ParsePolicy(s.config.GridLosses.InputQueuePolicy)
+	if err != nil {
+		return err
+	}
+
+	outputPolicy, err := point_queue.ParsePolicy(s.config.GridLosses.OutputQueuePolicy)
+	if err != nil {
+		return err
+	}
+
+	queueLength := s.config.GridLosses.QueueLength
+	warnPercent := s.config.GridLosses.QueueWarnPercent
+
+	s.inputDataQueue = point_queue.New(queueLength, inputPolicy, warnPercent)
+	s.inputDataQueue.SetWatermarkHandler(queueWatermarkHandler("input"))
+
+	s.outputDataQueue = point_queue.New(queueLength, outputPolicy, warnPercent)
+	s.outputDataQueue.SetWatermarkHandler(queueWatermarkHandler("output"))
+
+	s.switchDataQueue = point_queue.New(queueLength, point_queue.PolicyBlock, warnPercent)
+	s.switchDataQueue.SetWatermarkHandler(queueWatermarkHandler("switch"))
+
+	s.switchedQueue = make(chan SwitchedStruct)
+
+	llog.Logger.Infof("Queues: length %d, input %s, output %s, switch %s",
+		queueLength, inputPolicy, outputPolicy, point_queue.PolicyBlock)
+	return nil
+}
+
+func queueWatermarkHandler(name string) point_queue.WatermarkHandler {
+	return func(length int, capacity int, isHigh bool) {
+		if isHigh {
+			llog.Logger.Warnf("The %s queue is filling up: %d of %d", name, length, capacity)
+		} else {
+			llog.Logger.Infof("The %s queue is back to %d of %d", name, length, capacity)
+		}
+	}
+}
+
+// publishPoint to RTDB input through the output queue
+func (s *ThisService) publishPoint(point types.RtdbMessage) {
+	if err := s.outputDataQueue.Push(point); err != nil {
+		llog.Logger.Warnf("Point %d was not published: %v", point.Id, err)
+	}
+}
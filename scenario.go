@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/PVKonovalov/topogrid"
+	"gopkg.in/yaml.v3"
+	"grid_losses/bus"
+	"grid_losses/codec"
+	"grid_losses/llog"
+	"grid_losses/types"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const DefaultScenarioTimeoutMs = 1000
+const DefaultScenarioTolerance = 1e-3
+
+// ScenarioStruct describes the profiles, the configuration and the steps of the scenario.
+// Paths are relative to the scenario file
+type ScenarioStruct struct {
+	Config        string `yaml:"config"`
+	Topology      string `yaml:"topology,omitempty"`
+	Equipment     string `yaml:"equipment,omitempty"`
+	Cim           string `yaml:"cim,omitempty"`
+	Interrogation bool   `yaml:"interrogation,omitempty"` // Start with GI, otherwise the losses are published at once
+	TimeoutMs     int    `yaml:"timeout,omitempty"`       // Time to wait for the expected output of a step
+	Steps         []struct {
+		Name  string `yaml:"name,omitempty"`
+		Input []struct {
+			Id      uint64  `yaml:"id"`
+			Value   float32 `yaml:"v"`
+			Quality uint32  `yaml:"qds,omitempty"`
+			Select  int     `yaml:"s,omitempty"`
+			Execute int     `yaml:"e,omitempty"`
+			Source  uint32  `yaml:"src,omitempty"`
+			TimeMs  *int64  `yaml:"ts,omitempty"` // Timestamp relative to the scenario start, ms, the current time if empty
+		} `yaml:"input,omitempty"`
+		Command *struct {
+			Equipment int     `yaml:"equipment"`
+			Value     float32 `yaml:"value"`
+		} `yaml:"command,omitempty"` // Sent before the input
+		Commands map[int]string `yaml:"commands,omitempty"` // Expected state of the commands by id
+		Expect   []struct {
+			Id        uint64  `yaml:"id"`
+			Value     float64 `yaml:"v"`
+			Tolerance float64 `yaml:"tolerance,omitempty"`
+		} `yaml:"expect,omitempty"`
+		Energized map[int]bool `yaml:"energized,omitempty"` // Expected electrical state of the equipment
+		Stats     *InputStats  `yaml:"stats,omitempty"`     // Expected counters of the dropped input
+	} `yaml:"steps"`
+}
+
+// LoadScenario from YAML file
+func LoadScenario(path string) (*ScenarioStruct, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenario ScenarioStruct
+	if err = yaml.Unmarshal(data, &scenario); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Dir(path)
+	for _, file := range []*string{&scenario.Config, &scenario.Topology, &scenario.Equipment, &scenario.Cim} {
+		if *file != "" && !filepath.IsAbs(*file) {
+			*file = filepath.Join(dir, *file)
+		}
+	}
+
+	if scenario.TimeoutMs <= 0 {
+		scenario.TimeoutMs = DefaultScenarioTimeoutMs
+	}
+
+	return &scenario, nil
+}
+
+// RunScenario runs the service over the memory bus: injects the input of each step and checks the published losses
+// and the electrical state of the equipment. Returns the failed checks
+func RunScenario(path string) error {
+	return runScenario(path, nil)
+}
+
+// runScenario starts the background work (if set) when the service is running, the returned function
+// must stop it before the service is shut down
+func runScenario(path string, background func(s *ThisService) func()) error {
+	scenario, err := LoadScenario(path)
+	if err != nil {
+		return err
+	}
+
+	s := NewService()
+
+	if scenario.Config != "" {
+		if err = s.config.LoadFromFile(scenario.Config); err != nil {
+			return fmt.Errorf("configuration (%s): %v", scenario.Config, err)
+		}
+	}
+
+	if err = s.SetTimestampFormat(); err != nil {
+		return err
+	}
+
+	if err = s.CheckCommandConfig(); err != nil {
+		return err
+	}
+
+	if s.inputBusCodec, err = codec.ByName(s.config.Rtdb.InputCodec); err != nil {
+		return err
+	}
+
+	if s.outputBusCodec, err = codec.ByName(s.config.Rtdb.OutputCodec); err != nil {
+		return err
+	}
+
+	if s.cachePath, err = os.MkdirTemp("", "grid_losses_scenario"); err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.RemoveAll(s.cachePath)
+	}()
+
+	if scenario.Cim != "" {
+		err = s.LoadProfilesFromCimFile(scenario.Cim)
+	} else if err = s.LoadTopologyProfileFromFile(scenario.Topology); err == nil {
+		err = s.LoadEquipmentProfileFromFile(scenario.Equipment)
+	}
+	if err != nil {
+		return fmt.Errorf("profiles: %v", err)
+	}
+
+	s.CreateInternalParametersFromProfiles()
+	s.CreateBranchLossesFromConfig()
+	s.CreateSourcesFromConfig()
+	s.CreatePointStore()
+	s.CreateCycle()
+
+	if s.config.GridLosses.QueueLength <= 0 {
+		s.config.GridLosses.QueueLength = 1000
+	}
+
+	if err = s.CreateQueues(); err != nil {
+		return err
+	}
+
+	if err = s.LoadTopologyGrid(); err != nil {
+		return fmt.Errorf("topology: %v", err)
+	}
+
+	s.topologyGrid.SetEquipmentElectricalState()
+
+	memory := bus.NewMemoryTransport(s.config.GridLosses.QueueLength)
+	s.bus = memory
+	s.bus.SetStateHandler(s.BusStateHandler)
+
+	var topics []string
+	if s.IsTopicSubscription() {
+		topics = s.SubscribeTopics()
+	}
+	_ = s.bus.Subscribe(topics, s.ReceiveDataHandler)
+
+	if scenario.Interrogation {
+		s.StartInterrogation("scenario")
+	} else {
+		s.interrogation.isInitialSnapshotComplete = true
+	}
+
+	go s.SwitchDataWorker()
+	go s.ReceiveDataWorker()
+	go s.OutputEventWorker()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	busDone := make(chan error)
+	go func() {
+		busDone <- s.bus.Run(ctx)
+	}()
+
+	stopBackground := func() {}
+	if background != nil {
+		stopBackground = background(s)
+	}
+
+	var failures []error
+	valueFromPointId := make(map[uint64]float64)
+	timeout := time.Duration(scenario.TimeoutMs) * time.Millisecond
+	startedAt := time.Now()
+
+	for stepIdx, step := range scenario.Steps {
+		name := step.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", stepIdx+1)
+		}
+
+		if step.Command != nil {
+			if _, err := s.SendCommand(step.Command.Equipment, step.Command.Value); err != nil {
+				failures = append(failures, fmt.Errorf("step %s: command: %v", name, err))
+			}
+		}
+
+		if len(step.Input) > 0 {
+			messages := make([]types.RtdbMessage, 0, len(step.Input))
+			for _, point := range step.Input {
+				timestamp := time.Now()
+				if point.TimeMs != nil {
+					timestamp = startedAt.Add(time.Duration(*point.TimeMs) * time.Millisecond)
+				}
+				messages = append(messages, types.RtdbMessage{
+					Id:        point.Id,
+					Value:     point.Value,
+					Quality:   point.Quality,
+					Select:    point.Select,
+					Execute:   point.Execute,
+					Source:    point.Source,
+					Timestamp: types.IsoDate{Time: timestamp},
+				})
+			}
+			data, err := s.outputBusCodec.Marshal(messages)
+			if err != nil {
+				return err
+			}
+			memory.Inject(string(data))
+		}
+
+		isExpected := func() bool {
+			for _, expect := range step.Expect {
+				tolerance := expect.Tolerance
+				if tolerance == 0 {
+					tolerance = DefaultScenarioTolerance
+				}
+				value, exists := valueFromPointId[expect.Id]
+				if !exists || math.Abs(value-expect.Value) > tolerance {
+					return false
+				}
+			}
+			return true
+		}
+
+		deadline := time.After(timeout)
+		for isWaiting := len(step.Expect) > 0 && !isExpected(); isWaiting; {
+			select {
+			case msg := <-memory.Published():
+				points, err := s.inputBusCodec.Unmarshal(msg.Data)
+				if err != nil {
+					failures = append(failures, fmt.Errorf("step %s: published data (%s): %v", name, msg.Data, err))
+					continue
+				}
+				for _, point := range points {
+					valueFromPointId[point.Id] = float64(point.Value)
+				}
+				isWaiting = !isExpected()
+			case <-deadline:
+				isWaiting = false
+			}
+		}
+
+		for _, expect := range step.Expect {
+			tolerance := expect.Tolerance
+			if tolerance == 0 {
+				tolerance = DefaultScenarioTolerance
+			}
+			if value, exists := valueFromPointId[expect.Id]; !exists {
+				failures = append(failures, fmt.Errorf("step %s: point %d was not published in %v", name, expect.Id, timeout))
+			} else if math.Abs(value-expect.Value) > tolerance {
+				failures = append(failures, fmt.Errorf("step %s: point %d = %g, expected %g ± %g", name, expect.Id, value, expect.Value, tolerance))
+			}
+		}
+
+		if len(step.Energized) > 0 {
+			failures = append(failures, s.checkEnergized(name, step.Energized, timeout)...)
+		}
+
+		if len(step.Commands) > 0 {
+			failures = append(failures, s.checkCommands(name, step.Commands, timeout)...)
+		}
+
+		if step.Stats != nil {
+			failures = append(failures, s.checkInputStats(name, *step.Stats, timeout)...)
+		}
+
+		llog.Logger.Infof("Scenario step %s done", name)
+	}
+
+	stopBackground()
+
+	// Keep reading the output until the bus is closed, so the shutdown is not blocked by the full queue
+	go func() {
+		for range memory.Published() {
+		}
+	}()
+
+	cancel()
+	<-busDone
+
+	if err = s.Shutdown(ShutdownTimeoutSec * time.Second); err != nil {
+		failures = append(failures, fmt.Errorf("shutdown: %v", err))
+	}
+
+	return errors.Join(failures...)
+}
+
+// checkEnergized waits for the expected electrical state of the equipment applied by SwitchDataWorker
+func (s *ThisService) checkEnergized(name string, energized map[int]bool, timeout time.Duration) []error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var failures []error
+
+		s.modelLock.RLock()
+		for equipmentId, isExpected := range energized {
+			state, exists := s.topologyGrid.EquipmentElectricalStateByEquipmentId(equipmentId)
+			if !exists {
+				failures = append(failures, fmt.Errorf("step %s: equipment %d is not in the topology", name, equipmentId))
+				continue
+			}
+			if isEnergized := state&topogrid.StateEnergized == topogrid.StateEnergized; isEnergized != isExpected {
+				failures = append(failures, fmt.Errorf("step %s: equipment %d energized = %v, expected %v",
+					name, equipmentId, isEnergized, isExpected))
+			}
+		}
+		s.modelLock.RUnlock()
+
+		if len(failures) == 0 || time.Now().After(deadline) {
+			return failures
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkCommands waits for the expected state of the commands
+func (s *ThisService) checkCommands(name string, stateFromCommandId map[int]string, timeout time.Duration) []error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		var failures []error
+
+		stateFromId := make(map[int]string)
+		for _, command := range s.Commands() {
+			stateFromId[command.Id] = command.State.String()
+		}
+
+		for id, state := range stateFromCommandId {
+			if stateFromId[id] != state {
+				failures = append(failures, fmt.Errorf("step %s: command %d is %q, expected %q", name, id, stateFromId[id], state))
+			}
+		}
+
+		if len(failures) == 0 || time.Now().After(deadline) {
+			return failures
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkInputStats waits for the expected counters of the dropped input
+func (s *ThisService) checkInputStats(name string, expected InputStats, timeout time.Duration) []error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		stats := s.InputStats()
+		if stats == expected {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return []error{fmt.Errorf("step %s: input stats are %+v, expected %+v", name, stats, expected)}
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
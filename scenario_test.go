@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// scenarioFiles returns all scenarios of the repository
+func scenarioFiles(t *testing.T) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join("scenarios", "*", "scenario.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no scenarios found")
+	}
+	return files
+}
+
+func TestScenarios(t *testing.T) {
+	for _, file := range scenarioFiles(t) {
+		t.Run(filepath.Base(filepath.Dir(file)), func(t *testing.T) {
+			if err := RunScenario(file); err != nil {
+				t.Fatalf("%s:\n%v", file, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"grid_losses/llog"
+	"time"
+)
+
+const ShutdownTimeoutSec = 10
+
+// Exit codes
+const (
+	ExitCodeOk              int = 0
+	ExitCodeBusFailure      int = 1
+	ExitCodeShutdownFailure int = 2
+)
+
+// updateModel passes the update to ReceiveDataWorker. Returns false if the service is shutting down
+func (s *ThisService) updateModel(update func()) bool {
+	select {
+	case s.modelUpdateQueue <- update:
+		return true
+	case <-s.shutdown:
+		return false
+	}
+}
+
+// Shutdown stops the service after the bus receive loop has returned: processes the points left in
+// inputDataQueue, publishes the remaining output, logs out from the configuration API, saves the loss counters
+// and closes the bus
+func (s *ThisService) Shutdown(timeout time.Duration) error {
+	close(s.shutdown)
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	var errs []error
+
+	if s.httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := s.httpServer.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("http api: %v", err))
+		}
+		cancel()
+	}
+
+	llog.Logger.Infof("Processing %d points left in the input queue and %d in the switch queue",
+		s.inputDataQueue.Len(), s.switchDataQueue.Len())
+	s.inputDataQueue.Close()
+	s.switchDataQueue.Close()
+
+	isOutputDrained := false
+
+	select {
+	case <-s.receiveWorkerDone:
+		llog.Logger.Infof("Publishing %d events left in the output queue", s.outputDataQueue.Len())
+		s.outputDataQueue.Close()
+
+		select {
+		case <-s.outputWorkerDone:
+			isOutputDrained = true
+		case <-deadline.C:
+			errs = append(errs, fmt.Errorf("%d events were not published in %v", s.outputDataQueue.Len(), timeout))
+		}
+	case <-deadline.C:
+		errs = append(errs, fmt.Errorf("%d points were not processed in %v", s.inputDataQueue.Len()+s.switchDataQueue.Len(), timeout))
+	}
+
+	if s.profileLoader != nil {
+		if err := s.profileLoader.Logout(); err != nil {
+			llog.Logger.Warnf("Failed to logout from configuration API: %v", err)
+		}
+	}
+
+	if err := s.SaveLossCounters(); err != nil {
+		errs = append(errs, fmt.Errorf("loss counters: %v", err))
+	}
+
+	// The output worker may still be sending if the queue was not drained in time
+	if isOutputDrained {
+		if err := s.bus.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("bus: %v", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"grid_losses/types"
+	"time"
+)
+
+// SourceStruct is the configured priority of the values from the source
+type SourceStruct struct {
+	priority  int
+	isIgnored bool
+}
+
+// CreateSourcesFromConfig creates the priorities and the filters of the sources
+func (s *ThisService) CreateSourcesFromConfig() {
+	s.sourceFromSourceId = make(map[uint32]SourceStruct, len(s.config.Rtdb.Sources))
+	for _, source := range s.config.Rtdb.Sources {
+		s.sourceFromSourceId[source.Id] = SourceStruct{priority: source.Priority, isIgnored: source.Ignore}
+	}
+}
+
+// isSourceAccepted drops the messages published by this service, including its own commands looped back by the bus,
+// and the values from the ignored sources. Command confirmations of the other sources are never ignored.
+// Called from the bus handler
+func (s *ThisService) isSourceAccepted(point types.RtdbMessage) bool {
+	if s.config.Rtdb.SourceId != 0 && point.Source == s.config.Rtdb.SourceId {
+		return false
+	}
+
+	if point.Select != 0 || point.Execute != 0 {
+		return true
+	}
+
+	return !s.sourceFromSourceId[point.Source].isIgnored
+}
+
+// isPriorityAccepted rejects the value from the source with lower priority than the source of the last value
+// unless the last value is invalid or older than the source timeout. Safe to call from ReceiveDataWorker and
+// SwitchDataWorker: pointStore is locked and the sources are not changed after the start
+func (s *ThisService) isPriorityAccepted(point types.RtdbMessage) bool {
+	last, exists := s.pointStore.Get(point.Id)
+	if !exists || last.Source == point.Source {
+		return true
+	}
+
+	if s.sourceFromSourceId[point.Source].priority >= s.sourceFromSourceId[last.Source].priority {
+		return true
+	}
+
+	if last.Quality&types.QualityInvalid != 0 {
+		return true
+	}
+
+	timeout := time.Duration(s.config.Rtdb.SourceTimeoutSec) * time.Second
+	if timeout > 0 && time.Since(last.Timestamp.Time) > timeout {
+		return true
+	}
+
+	llog.Logger.Debugf("Value of point %d from source %d is ignored, the value from source %d has higher priority",
+		point.Id, point.Source, last.Source)
+	return false
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"grid_losses/llog"
+	"grid_losses/types"
+)
+
+// SwitchedStruct is the result of applying the queued switch states
+type SwitchedStruct struct {
+	receivedPointIds []uint64 // States received including the duplicates and the out-of-order ones
+	isChanged        bool     // The electrical state has been recalculated
+}
+
+// SwitchDataWorker applies the switch states to the topology and recalculates the electrical state once for all
+// the states queued, so the measurements are processed by ReceiveDataWorker meanwhile.
+// The results are passed to ReceiveDataWorker until switchDataQueue is closed
+func (s *ThisService) SwitchDataWorker() {
+	defer close(s.switchedQueue)
+
+	for {
+		<-s.switchDataQueue.Ready()
+
+		var points []types.RtdbMessage
+		for point, ok := s.switchDataQueue.Pop(); ok; point, ok = s.switchDataQueue.Pop() {
+			points = append(points, point)
+		}
+
+		if len(points) > 0 {
+			s.switchedQueue <- s.applySwitchStates(points)
+		}
+
+		if s.switchDataQueue.IsDone() {
+			return
+		}
+	}
+}
+
+// applySwitchStates to the topology. Must be called from SwitchDataWorker
+func (s *ThisService) applySwitchStates(points []types.RtdbMessage) SwitchedStruct {
+	var switched SwitchedStruct
+
+	s.modelLock.Lock()
+
+	for _, point := range points {
+		if !s.isInOrder(point) {
+			switched.receivedPointIds = append(switched.receivedPointIds, point.Id)
+			continue
+		}
+		if !s.isPriorityAccepted(point) {
+			continue
+		}
+
+		s.pointStore.Set(point)
+		switched.receivedPointIds = append(switched.receivedPointIds, point.Id)
+
+		resource, exists := s.resourceStructFromPointId[point.Id]
+		if !exists || resource.resourceTypeId != ResourceTypeState {
+			continue
+		}
+
+		llog.Logger.Debugf("Toggle: %+v", point)
+
+		if err := s.topologyGrid.SetSwitchStateByEquipmentId(resource.equipmentId, int(point.Value)); err != nil {
+			llog.Logger.Warnf("Failed to change state: %v", err)
+			continue
+		}
+		switched.isChanged = true
+	}
+
+	if switched.isChanged {
+		s.topologyGrid.SetEquipmentElectricalState()
+	}
+
+	s.modelLock.Unlock()
+
+	return switched
+}
+
+// applySwitched states to the model and recalculates the losses. Must be called from ReceiveDataWorker
+func (s *ThisService) applySwitched(switched SwitchedStruct) {
+	for _, pointId := range switched.receivedPointIds {
+		s.trackInterrogation(pointId)
+	}
+
+	if switched.isChanged {
+		s.scheduleAllBranchLosses()
+	}
+}
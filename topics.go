@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PointTopic returns the topic of the point. The format without %d is used as a prefix for all points
+func PointTopic(format string, pointId uint64) string {
+	if strings.Contains(format, "%d") {
+		return fmt.Sprintf(format, pointId)
+	}
+	return format
+}
+
+// IsTopicSubscription is true if RTDB publishes the points with topics
+func (s *ThisService) IsTopicSubscription() bool {
+	return len(s.config.Rtdb.SubscribeTopics) > 0 || s.config.Rtdb.PointTopic != ""
+}
+
+// SubscribeTopics returns the configured topics and the topics of the points used by the topology and the losses calculation
+func (s *ThisService) SubscribeTopics() []string {
+	topics := append([]string{}, s.config.Rtdb.SubscribeTopics...)
+
+	if s.config.Rtdb.PointTopic == "" {
+		return topics
+	}
+
+	pointIds := make([]uint64, 0)
+	for pointId := range s.usedPointIds() {
+		pointIds = append(pointIds, pointId)
+	}
+	if s.config.GridLosses.Commands.Enabled {
+		for pointId := range s.equipmentIdFromControlPointId {
+			pointIds = append(pointIds, pointId)
+		}
+	}
+	sort.Slice(pointIds, func(i, j int) bool { return pointIds[i] < pointIds[j] })
+
+	for _, pointId := range pointIds {
+		topics = append(topics, PointTopic(s.config.Rtdb.PointTopic, pointId))
+	}
+
+	return topics
+}
+
+// UpdateSubscriptions after the points used by the model have been changed
+func (s *ThisService) UpdateSubscriptions() {
+	if s.bus == nil || s.config.Rtdb.PointTopic == "" {
+		return
+	}
+	s.bus.SetTopics(s.SubscribeTopics())
+}
@@ -0,0 +1,150 @@
+package types
+
+import "encoding/json"
+
+// Profiles of the configuration API
+
+type EdgeStruct struct {
+	EquipmentType           string      `json:"equipment_type,omitempty"`
+	EquipmentName           string      `json:"equipment_name,omitempty"`
+	EquipmentId             int         `json:"equipment_id,omitempty"`
+	EquipmentTypeId         int         `json:"equipment_type_id,omitempty"`
+	EquipmentVoltageClassId int         `json:"equipment_voltage_class_id,omitempty"`
+	Id                      int         `json:"id"`
+	StateNormal             int         `json:"state_normal"`
+	Terminal1               int         `json:"terminal1"`
+	Terminal2               int         `json:"terminal2"`
+	Coordinates             [][]float64 `json:"coordinates,omitempty"`
+}
+
+type NodeStruct struct {
+	EquipmentId             int       `json:"equipment_id,omitempty"`
+	EquipmentName           string    `json:"equipment_name,omitempty"`
+	EquipmentTypeId         int       `json:"equipment_type_id,omitempty"`
+	EquipmentVoltageClassId int       `json:"equipment_voltage_class_id,omitempty"`
+	Id                      int       `json:"id"`
+	Coordinates             []float64 `json:"coordinates,omitempty"`
+}
+
+type TopologyStruct struct {
+	Edge []EdgeStruct `json:"edge"`
+	Node []NodeStruct `json:"node"`
+}
+
+type EquipmentResourceStruct struct {
+	Id          int    `json:"id"`
+	Point       string `json:"point"`
+	PointId     uint64 `json:"point_id"`
+	PointTypeId int    `json:"point_type_id"`
+	Type        string `json:"type"`
+	TypeId      int    `json:"type_id"`
+}
+
+type EquipmentStruct struct {
+	EquipmentType         string                    `json:"equipment_type,omitempty"`
+	EquipmentVoltageClass string                    `json:"equipment_voltage_class"`
+	Id                    int                       `json:"id"`
+	Name                  string                    `json:"name"`
+	TypeId                int                       `json:"type_id,omitempty"`
+	VoltageClassId        int                       `json:"voltage_class_id"`
+	Resource              []EquipmentResourceStruct `json:"resource,omitempty"`
+}
+
+// ParseTopologyData Parse topology profile
+func ParseTopologyData(data []byte) (*TopologyStruct, error) {
+	var topologyStruct TopologyStruct
+	err := json.Unmarshal(data, &topologyStruct)
+	return &topologyStruct, err
+}
+
+// ParseEquipmentData Parse equipment profile
+func ParseEquipmentData(data []byte) (*[]EquipmentStruct, error) {
+	var equipmentStructs []EquipmentStruct
+	err := json.Unmarshal(data, &equipmentStructs)
+	return &equipmentStructs, err
+}
+
+// TopologyChangesStruct incremental changes of the topology profile after a revision
+type TopologyChangesStruct struct {
+	Revision    int64        `json:"revision"`
+	Edge        []EdgeStruct `json:"edge,omitempty"`
+	Node        []NodeStruct `json:"node,omitempty"`
+	DeletedEdge []int        `json:"deleted_edge,omitempty"`
+	DeletedNode []int        `json:"deleted_node,omitempty"`
+}
+
+// EquipmentChangesStruct incremental changes of the equipment profile after a revision
+type EquipmentChangesStruct struct {
+	Revision int64             `json:"revision"`
+	Changed  []EquipmentStruct `json:"changed,omitempty"`
+	Deleted  []int             `json:"deleted,omitempty"`
+}
+
+// IsEmpty returns true if there are no changes
+func (c *TopologyChangesStruct) IsEmpty() bool {
+	return len(c.Edge) == 0 && len(c.Node) == 0 && len(c.DeletedEdge) == 0 && len(c.DeletedNode) == 0
+}
+
+// IsEmpty returns true if there are no changes
+func (c *EquipmentChangesStruct) IsEmpty() bool {
+	return len(c.Changed) == 0 && len(c.Deleted) == 0
+}
+
+// ApplyChanges adds or replaces nodes and edges by id and removes the deleted ones
+func (t *TopologyStruct) ApplyChanges(changes *TopologyChangesStruct) {
+	deletedNode := make(map[int]bool, len(changes.DeletedNode))
+	for _, id := range changes.DeletedNode {
+		deletedNode[id] = true
+	}
+
+	changedNode := make(map[int]NodeStruct, len(changes.Node))
+	for _, node := range changes.Node {
+		changedNode[node.Id] = node
+	}
+
+	nodes := make([]NodeStruct, 0, len(t.Node)+len(changes.Node))
+	for _, node := range t.Node {
+		if deletedNode[node.Id] {
+			continue
+		}
+		if changed, exists := changedNode[node.Id]; exists {
+			node = changed
+			delete(changedNode, node.Id)
+		}
+		nodes = append(nodes, node)
+	}
+	for _, node := range changes.Node {
+		if _, isNew := changedNode[node.Id]; isNew {
+			nodes = append(nodes, node)
+		}
+	}
+	t.Node = nodes
+
+	deletedEdge := make(map[int]bool, len(changes.DeletedEdge))
+	for _, id := range changes.DeletedEdge {
+		deletedEdge[id] = true
+	}
+
+	changedEdge := make(map[int]EdgeStruct, len(changes.Edge))
+	for _, edge := range changes.Edge {
+		changedEdge[edge.Id] = edge
+	}
+
+	edges := make([]EdgeStruct, 0, len(t.Edge)+len(changes.Edge))
+	for _, edge := range t.Edge {
+		if deletedEdge[edge.Id] || deletedNode[edge.Terminal1] || deletedNode[edge.Terminal2] {
+			continue
+		}
+		if changed, exists := changedEdge[edge.Id]; exists {
+			edge = changed
+			delete(changedEdge, edge.Id)
+		}
+		edges = append(edges, edge)
+	}
+	for _, edge := range changes.Edge {
+		if _, isNew := changedEdge[edge.Id]; isNew {
+			edges = append(edges, edge)
+		}
+	}
+	t.Edge = edges
+}
@@ -2,7 +2,10 @@ package types
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,17 +14,107 @@ type IsoDate struct {
 	time.Time
 }
 
-func (c *IsoDate) UnmarshalJSON(b []byte) (err error) {
-	s := strings.Trim(string(b), `"`) // remove quotes
-	c.Time, err = time.Parse("2006-01-02T15:04:05.999-0700", s)
-	if err != nil {
-		c.Time = time.Now()
+// IsoDateLayout is the RTDB timestamp format
+const IsoDateLayout = "2006-01-02T15:04:05.999-0700"
+
+// Output formats of IsoDate besides a time layout
+const (
+	IsoDateFormatUnix      = "unix"
+	IsoDateFormatUnixMilli = "unixms"
+)
+
+// QualityInvalid is the IV bit of QDS. In the confirmation of a command it means the command was rejected
+const QualityInvalid uint32 = 0x80
+
+// QualityTimestampInvalid is set in RtdbMessage.Quality when the timestamp can not be parsed. Not a QDS bit
+const QualityTimestampInvalid uint32 = 0x0100
+
+// Numbers from this value are Unix milliseconds, smaller ones are Unix seconds (1e11 s is the year 5138)
+const unixMilliThreshold = 1e11
+
+var ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+// Layouts accepted in the string timestamps. The fractional seconds are accepted by all of them
+var isoDateLayouts = []string{
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05Z07:00",
+}
+
+var isoDateFormat = IsoDateLayout
+
+// IsStrictTimestamp rejects the messages with invalid timestamps instead of marking them with QualityTimestampInvalid
+var IsStrictTimestamp = false
+
+// SetIsoDateFormat sets the output format of IsoDate: a time layout, rfc3339, rfc3339nano, unix or unixms.
+// Empty format is the RTDB one
+func SetIsoDateFormat(format string) error {
+	switch strings.ToLower(format) {
+	case "":
+		isoDateFormat = IsoDateLayout
+	case "rfc3339":
+		isoDateFormat = time.RFC3339
+	case "rfc3339nano":
+		isoDateFormat = time.RFC3339Nano
+	case IsoDateFormatUnix, IsoDateFormatUnixMilli:
+		isoDateFormat = strings.ToLower(format)
+	default:
+		if !strings.Contains(format, "2006") {
+			return fmt.Errorf("unknown timestamp format %s", format)
+		}
+		isoDateFormat = format
+	}
+	return nil
+}
+
+// ParseIsoDate parses a JSON timestamp: a string in ISO 8601/RFC 3339 format with Z or an offset with or
+// without a colon, a number or a numeric string of Unix seconds or milliseconds, or null (zero time)
+func ParseIsoDate(b []byte) (time.Time, error) {
+	value := strings.TrimSpace(string(b))
+
+	if value == "null" || value == `""` {
+		return time.Time{}, nil
+	}
+
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = strings.TrimSpace(unquoted)
+	}
+
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.Abs(number) >= unixMilliThreshold {
+			return time.UnixMilli(int64(number)), nil
+		}
+		seconds, fraction := math.Modf(number)
+		return time.Unix(int64(seconds), int64(fraction*1e9)), nil
 	}
+
+	for _, layout := range isoDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidTimestamp, value)
+}
+
+func (c *IsoDate) UnmarshalJSON(b []byte) (err error) {
+	c.Time, err = ParseIsoDate(b)
 	return
 }
 
 func (c *IsoDate) MarshalJSON() ([]byte, error) {
-	str := c.Time.Format("2006-01-02T15:04:05.999-0700")
+	switch isoDateFormat {
+	case IsoDateFormatUnix, IsoDateFormatUnixMilli:
+		if c.Time.IsZero() {
+			return []byte("null"), nil
+		}
+		if isoDateFormat == IsoDateFormatUnix {
+			return []byte(strconv.FormatInt(c.Time.Unix(), 10)), nil
+		}
+		return []byte(strconv.FormatInt(c.Time.UnixMilli(), 10)), nil
+	}
+	str := c.Time.Format(isoDateFormat)
 	return []byte("\"" + str + "\""), nil
 }
 
@@ -45,6 +138,43 @@ func (c RtdbMessage) String() string {
 	return fmt.Sprintf("[%d V: %.4f T: %s Q: %d S: %d G: %d]", c.Id, c.Value, c.Timestamp, c.Quality, c.Source, c.TimestampFromClient)
 }
 
+// UnmarshalJSON marks the message with QualityTimestampInvalid if a timestamp can not be parsed
+// or returns the error if IsStrictTimestamp is set
+func (c *RtdbMessage) UnmarshalJSON(b []byte) error {
+	type rtdbMessage RtdbMessage
+
+	message := struct {
+		*rtdbMessage
+		Timestamp     json.RawMessage `json:"ts"`
+		TimestampRecv json.RawMessage `json:"tsr"`
+	}{rtdbMessage: (*rtdbMessage)(c)}
+
+	if err := json.Unmarshal(b, &message); err != nil {
+		return err
+	}
+
+	var err, errRecv error
+
+	c.Timestamp.Time, err = parseRawIsoDate(message.Timestamp)
+	c.TimestampRecv.Time, errRecv = parseRawIsoDate(message.TimestampRecv)
+
+	if err = errors.Join(err, errRecv); err != nil {
+		if IsStrictTimestamp {
+			return fmt.Errorf("point %d: %w", c.Id, err)
+		}
+		c.Quality |= QualityTimestampInvalid
+	}
+
+	return nil
+}
+
+func parseRawIsoDate(b json.RawMessage) (time.Time, error) {
+	if len(b) == 0 {
+		return time.Time{}, nil
+	}
+	return ParseIsoDate(b)
+}
+
 // ParseScadaRtdbData Parse data from Scada RTDB to Go internal types
 func ParseScadaRtdbData(data []byte) ([]RtdbMessage, error) {
 	var rtdbMessage []RtdbMessage
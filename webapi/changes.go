@@ -0,0 +1,153 @@
+package webapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grid_losses/types"
+	"hash/crc32"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const ApiGetTopologyChanges = "/api/topology/graph/changes"
+const ApiGetEquipmentChanges = "/api/equipment/changes"
+
+// RevisionHeader is the revision of the profile returned by the server with the full profile
+const RevisionHeader = "X-Revision"
+
+var ErrNotModified = errors.New("not modified")
+
+// Validators of the last received profile for the conditional (ETag, Last-Modified)
+// and the incremental (Revision) requests. ChecksumIEEE of the last full profile detects the unchanged
+// profile from the servers not sending ETag or Last-Modified
+type Validators struct {
+	ETag         string
+	LastModified string
+	Revision     int64
+	ChecksumIEEE uint32
+}
+
+func ValidatorsFromHeader(header http.Header) Validators {
+	revision, _ := strconv.ParseInt(header.Get(RevisionHeader), 10, 64)
+	return Validators{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+		Revision:     revision,
+	}
+}
+
+// ValidatorsFromResponse returns the validators of the full profile received with the header
+func ValidatorsFromResponse(header http.Header, data []byte) Validators {
+	validators := ValidatorsFromHeader(header)
+	validators.ChecksumIEEE = crc32.ChecksumIEEE(data)
+	return validators
+}
+
+func (v *Validators) conditionalHeader() http.Header {
+	header := http.Header{}
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("If-Modified-Since", v.LastModified)
+	}
+	return header
+}
+
+// updated validators from the response keeping the known values the server did not return
+func (v Validators) updated(header http.Header) Validators {
+	received := ValidatorsFromHeader(header)
+	if received.ETag != "" {
+		v.ETag = received.ETag
+	}
+	if received.LastModified != "" {
+		v.LastModified = received.LastModified
+	}
+	if received.Revision != 0 {
+		v.Revision = received.Revision
+	}
+	return v
+}
+
+// GetIfModified returns the raw resource and the validators updated from the response, or ErrNotModified.
+// The full resource with the checksum of the validators is not modified too.
+// The passed validators are not changed, the caller stores the returned ones once the resource is applied
+func (c *Client) GetIfModified(ctx context.Context, path string, validators Validators) ([]byte, Validators, error) {
+	data, header, err := c.Connection.Get(ctx, c.ApiPrefix+path, validators.conditionalHeader())
+	if err != nil {
+		return nil, validators, err
+	}
+
+	checksum := crc32.ChecksumIEEE(data)
+	if validators.ChecksumIEEE != 0 && checksum == validators.ChecksumIEEE {
+		return nil, validators, ErrNotModified
+	}
+
+	received := validators.updated(header)
+	received.ChecksumIEEE = checksum
+	return data, received, nil
+}
+
+// GetTopologyIfModified returns the topology and its validators or ErrNotModified
+func (c *Client) GetTopologyIfModified(ctx context.Context, validators Validators) (*types.TopologyStruct, Validators, error) {
+	data, received, err := c.GetIfModified(ctx, ApiGetTopology, validators)
+	if err != nil {
+		return nil, validators, err
+	}
+	topology, err := types.ParseTopologyData(data)
+	if err != nil {
+		return nil, validators, err
+	}
+	return topology, received, nil
+}
+
+// GetEquipmentIfModified returns all equipment and its validators or ErrNotModified
+func (c *Client) GetEquipmentIfModified(ctx context.Context, validators Validators) ([]types.EquipmentStruct, Validators, error) {
+	data, received, err := c.GetIfModified(ctx, ApiGetEquipment, validators)
+	if err != nil {
+		return nil, validators, err
+	}
+	equipments, err := types.ParseEquipmentData(data)
+	if err != nil {
+		return nil, validators, err
+	}
+	return *equipments, received, nil
+}
+
+func (c *Client) getChanges(ctx context.Context, path string, since int64, result interface{}) error {
+	query := url.Values{}
+	query.Set("since", strconv.FormatInt(since, 10))
+
+	data, _, err := c.Connection.Get(ctx, c.ApiPrefix+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("unmarshal '%s': %w", path, err)
+	}
+	return nil
+}
+
+// GetTopologyChanges returns the topology changes after the revision.
+// The error wraps ErrNotFound if the server does not support the incremental changes
+func (c *Client) GetTopologyChanges(ctx context.Context, since int64) (*types.TopologyChangesStruct, error) {
+	var changes types.TopologyChangesStruct
+	if err := c.getChanges(ctx, ApiGetTopologyChanges, since, &changes); err != nil {
+		return nil, err
+	}
+	return &changes, nil
+}
+
+// GetEquipmentChanges returns the equipment changes after the revision.
+// The error wraps ErrNotFound if the server does not support the incremental changes
+func (c *Client) GetEquipmentChanges(ctx context.Context, since int64) (*types.EquipmentChangesStruct, error) {
+	var changes types.EquipmentChangesStruct
+	if err := c.getChanges(ctx, ApiGetEquipmentChanges, since, &changes); err != nil {
+		return nil, err
+	}
+	return &changes, nil
+}
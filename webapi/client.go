@@ -0,0 +1,183 @@
+package webapi
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"grid_losses/types"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const ApiGetTopology = "/api/topology/graph"
+const ApiGetEquipment = "/api/equipment"
+
+const RequestIdHeader = "X-Request-Id"
+const DefaultPageSize = 500
+
+var ErrNotFound = errors.New("not found")
+
+type requestIdKey struct{}
+
+// ApiError is returned for a non-2xx response. The fields Code, Message and Detail are filled
+// from the JSON body of the response if the server returned it
+type ApiError struct {
+	Method     string `json:"-"`
+	Url        string `json:"-"`
+	RequestId  string `json:"-"`
+	StatusCode int    `json:"-"`
+	Status     string `json:"-"`
+	Code       string `json:"code,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Detail     string `json:"detail,omitempty"`
+}
+
+func newApiError(method string, requestUrl string, requestId string, resp *http.Response, body []byte) *ApiError {
+	apiError := &ApiError{}
+	_ = json.Unmarshal(body, apiError)
+
+	apiError.Method = method
+	apiError.Url = requestUrl
+	apiError.RequestId = requestId
+	apiError.StatusCode = resp.StatusCode
+	apiError.Status = resp.Status
+
+	return apiError
+}
+
+func (e *ApiError) Error() string {
+	message := fmt.Sprintf("%s '%s': status (%s) (%d) request id %s", e.Method, e.Url, e.Status, e.StatusCode, e.RequestId)
+	if e.Message != "" {
+		message += ": " + e.Message
+	}
+	if e.Detail != "" {
+		message += " (" + e.Detail + ")"
+	}
+	return message
+}
+
+// Unwrap allows errors.Is(err, ErrUnauthorized) and errors.Is(err, ErrNotFound)
+func (e *ApiError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	}
+	return nil
+}
+
+// WithRequestId returns the context with the request id sent in X-Request-Id header
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+// RequestIdFromContext returns the request id from the context or a new random one
+func RequestIdFromContext(ctx context.Context) string {
+	if requestId, ok := ctx.Value(requestIdKey{}).(string); ok && requestId != "" {
+		return requestId
+	}
+	return NewRequestId()
+}
+
+func NewRequestId() string {
+	buf := make([]byte, 8)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// Client is a typed client of the configuration API
+type Client struct {
+	Connection *Connection
+	ApiPrefix  string
+	PageSize   int
+}
+
+func NewClient(connection *Connection, apiPrefix string) *Client {
+	return &Client{Connection: connection, ApiPrefix: apiPrefix, PageSize: DefaultPageSize}
+}
+
+func (c *Client) getJson(ctx context.Context, path string, query url.Values, result interface{}) error {
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+
+	data, _, err := c.Connection.Get(ctx, c.ApiPrefix+path, nil)
+	if err != nil {
+		return err
+	}
+
+	if err = json.Unmarshal(data, result); err != nil {
+		return fmt.Errorf("unmarshal '%s': %w", path, err)
+	}
+	return nil
+}
+
+// GetTopology returns the topology graph profile
+func (c *Client) GetTopology(ctx context.Context) (*types.TopologyStruct, error) {
+	var topology types.TopologyStruct
+	if err := c.getJson(ctx, ApiGetTopology, nil, &topology); err != nil {
+		return nil, err
+	}
+	return &topology, nil
+}
+
+// GetEquipment returns all equipment with resources
+func (c *Client) GetEquipment(ctx context.Context) ([]types.EquipmentStruct, error) {
+	var equipments []types.EquipmentStruct
+	if err := c.getJson(ctx, ApiGetEquipment, nil, &equipments); err != nil {
+		return nil, err
+	}
+	return equipments, nil
+}
+
+// GetEquipmentById returns the equipment or the error wrapping ErrNotFound
+func (c *Client) GetEquipmentById(ctx context.Context, id int) (*types.EquipmentStruct, error) {
+	var equipment types.EquipmentStruct
+	if err := c.getJson(ctx, ApiGetEquipment+"/"+strconv.Itoa(id), nil, &equipment); err != nil {
+		return nil, err
+	}
+	return &equipment, nil
+}
+
+// ListEquipment returns a page of equipment starting from offset. The page is shorter than limit at the end of the list
+func (c *Client) ListEquipment(ctx context.Context, offset int, limit int) ([]types.EquipmentStruct, error) {
+	query := url.Values{}
+	query.Set("offset", strconv.Itoa(offset))
+	query.Set("limit", strconv.Itoa(limit))
+
+	var equipments []types.EquipmentStruct
+	if err := c.getJson(ctx, ApiGetEquipment, query, &equipments); err != nil {
+		return nil, err
+	}
+	return equipments, nil
+}
+
+// ForEachEquipment iterates all equipment page by page. Iteration stops on the first error returned by fn
+func (c *Client) ForEachEquipment(ctx context.Context, fn func(equipment types.EquipmentStruct) error) error {
+	pageSize := c.PageSize
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	for offset := 0; ; offset += pageSize {
+		equipments, err := c.ListEquipment(ctx, offset, pageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, equipment := range equipments {
+			if err = fn(equipment); err != nil {
+				return err
+			}
+		}
+
+		if len(equipments) < pageSize {
+			return nil
+		}
+	}
+}
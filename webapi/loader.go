@@ -0,0 +1,272 @@
+package webapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultHedgeDelay = 2 * time.Second
+const DefaultBackoffMin = 1 * time.Second
+const DefaultBackoffMax = 30 * time.Second
+
+var ErrNoHosts = errors.New("no configuration API hosts")
+
+type hostHealth struct {
+	failures     int
+	responseTime float64 // Smoothed response time, seconds
+}
+
+// ProfileLoader fetches a set of profiles from the same WEB API host. The hosts are tried in parallel:
+// the healthiest host starts first, the others start with HedgeDelay one after another,
+// and the first host returned all profiles wins. The whole attempt is retried with exponential backoff.
+type ProfileLoader struct {
+	sync.Mutex
+	Hosts           []string
+	HostVirtualName string
+	UserName        string
+	Password        string
+	Timeout         time.Duration
+	Retries         int
+	HedgeDelay      time.Duration
+	BackoffMin      time.Duration
+	BackoffMax      time.Duration
+	Tls             TlsOptions
+	Proxy           string
+	Headers         map[string]string
+	health          map[string]*hostHealth
+	connections     map[string]*Connection
+}
+
+// ParseFunc validates and parses the profile data. A parse error fails the host, so the next host is tried
+type ParseFunc func(data []byte) (any, error)
+
+type LoadResult struct {
+	Host       string
+	Data       map[string][]byte     // Path -> profile data
+	Profiles   map[string]any        // Path -> parsed profile, if the parser is set
+	Validators map[string]Validators // Path -> validators for the conditional and incremental requests
+}
+
+type hostResult struct {
+	result *LoadResult
+	err    error
+}
+
+func NewProfileLoader(hosts []string, hostVirtualName string, username string, password string, timeout time.Duration) *ProfileLoader {
+	trimmedHosts := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if host = strings.TrimSpace(host); host != "" {
+			trimmedHosts = append(trimmedHosts, host)
+		}
+	}
+
+	return &ProfileLoader{
+		Hosts:           trimmedHosts,
+		HostVirtualName: hostVirtualName,
+		UserName:        username,
+		Password:        password,
+		Timeout:         timeout,
+		HedgeDelay:      DefaultHedgeDelay,
+		BackoffMin:      DefaultBackoffMin,
+		BackoffMax:      DefaultBackoffMax,
+		health:          make(map[string]*hostHealth),
+		connections:     make(map[string]*Connection),
+	}
+}
+
+// Load profiles by paths from the same host and parse them with the parsers of the paths (nil keeps the raw data only)
+func (l *ProfileLoader) Load(parsers map[string]ParseFunc) (*LoadResult, error) {
+	if len(l.Hosts) == 0 {
+		return nil, ErrNoHosts
+	}
+
+	backoff := l.BackoffMin
+	var err error
+	var result *LoadResult
+
+	for attempt := 0; attempt <= l.Retries; attempt++ {
+		if attempt != 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > l.BackoffMax {
+				backoff = l.BackoffMax
+			}
+		}
+
+		if result, err = l.loadOnce(parsers); err == nil {
+			return result, nil
+		}
+	}
+
+	return nil, err
+}
+
+// HostsByHealth returns hosts ordered by the number of recent failures and then by the response time
+func (l *ProfileLoader) HostsByHealth() []string {
+	l.Lock()
+	defer l.Unlock()
+
+	hosts := make([]string, len(l.Hosts))
+	copy(hosts, l.Hosts)
+
+	health := func(host string) hostHealth {
+		if h, exists := l.health[host]; exists {
+			return *h
+		}
+		return hostHealth{}
+	}
+
+	sort.SliceStable(hosts, func(i, j int) bool {
+		hi, hj := health(hosts[i]), health(hosts[j])
+		if hi.failures != hj.failures {
+			return hi.failures < hj.failures
+		}
+		return hi.responseTime < hj.responseTime
+	})
+
+	return hosts
+}
+
+// loadOnce returns the first host loaded all the profiles. The loadings of the other hosts are cancelled on return
+func (l *ProfileLoader) loadOnce(parsers map[string]ParseFunc) (*LoadResult, error) {
+	hosts := l.HostsByHealth()
+
+	results := make(chan hostResult, len(hosts))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for i, host := range hosts {
+		go func(host string, delay time.Duration) {
+			if delay != 0 {
+				select {
+				case <-time.After(delay):
+				case <-ctx.Done():
+					results <- hostResult{err: ctx.Err()}
+					return
+				}
+			}
+			result, err := l.loadFromHost(ctx, host, parsers)
+			results <- hostResult{result: result, err: err}
+		}(host, time.Duration(i)*l.HedgeDelay)
+	}
+
+	errs := make([]string, 0, len(hosts))
+
+	for range hosts {
+		r := <-results
+		if r.err == nil {
+			return r.result, nil
+		}
+		errs = append(errs, r.err.Error())
+	}
+
+	return nil, errors.New(strings.Join(errs, "; "))
+}
+
+// Connection to the host. The connection is reused by the next loadings, so the token is kept while it is valid
+func (l *ProfileLoader) Connection(host string) *Connection {
+	l.Lock()
+	defer l.Unlock()
+
+	api, exists := l.connections[host]
+	if !exists {
+		api = &Connection{
+			Timeout:         l.Timeout,
+			BaseUrl:         host,
+			HostVirtualName: l.HostVirtualName,
+			Tls:             l.Tls,
+			Proxy:           l.Proxy,
+			Headers:         l.Headers}
+		l.connections[host] = api
+	}
+	return api
+}
+
+// Logout from all the hosts the loader has connected to
+func (l *ProfileLoader) Logout() error {
+	l.Lock()
+	connections := make([]*Connection, 0, len(l.connections))
+	for _, api := range l.connections {
+		connections = append(connections, api)
+	}
+	l.Unlock()
+
+	var errs []error
+	for _, api := range connections {
+		if err := api.Logout(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", api.BaseUrl, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// loadFromHost downloads and parses the profiles. The cancelled loading does not change the health of the host
+func (l *ProfileLoader) loadFromHost(ctx context.Context, host string, parsers map[string]ParseFunc) (*LoadResult, error) {
+	api := l.Connection(host)
+
+	start := time.Now()
+
+	if !api.IsTokenValid() {
+		if _, err, responseTime := api.Logon(l.UserName, l.Password); err != nil {
+			l.updateHealth(host, false, responseTime)
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+	}
+
+	result := &LoadResult{
+		Host:       host,
+		Data:       make(map[string][]byte, len(parsers)),
+		Profiles:   make(map[string]any, len(parsers)),
+		Validators: make(map[string]Validators, len(parsers)),
+	}
+
+	for path, parse := range parsers {
+		data, header, err := api.Get(ctx, path, nil)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("%s: %w", host, ctx.Err())
+			}
+			l.updateHealth(host, false, time.Since(start).Seconds())
+			return nil, fmt.Errorf("%s: %v", host, err)
+		}
+		if parse != nil {
+			profile, err := parse(data)
+			if err != nil {
+				l.updateHealth(host, false, time.Since(start).Seconds())
+				return nil, fmt.Errorf("%s: %s: %v", host, path, err)
+			}
+			result.Profiles[path] = profile
+		}
+		result.Data[path] = data
+		result.Validators[path] = ValidatorsFromResponse(header, data)
+	}
+
+	l.updateHealth(host, true, time.Since(start).Seconds())
+	return result, nil
+}
+
+func (l *ProfileLoader) updateHealth(host string, isSuccess bool, responseTime float64) {
+	l.Lock()
+	defer l.Unlock()
+
+	h, exists := l.health[host]
+	if !exists {
+		h = &hostHealth{responseTime: responseTime}
+		l.health[host] = h
+	}
+
+	if isSuccess {
+		h.failures = 0
+	} else {
+		h.failures += 1
+	}
+
+	if responseTime > 0 {
+		h.responseTime = 0.7*h.responseTime + 0.3*responseTime
+	}
+}
@@ -5,104 +5,411 @@
 package webapi
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
+const ApiToken = "/api/token"
+const ApiTokenRefresh = "/api/token/refresh"
+const ApiLogout = "/api/logout"
+
+// TokenExpirySkew the token is renewed this time before the expiry
+const TokenExpirySkew = 30 * time.Second
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// TlsOptions for HTTPS connections
+type TlsOptions struct {
+	CaFile             string // PEM file with the certificate authorities, system pool if empty
+	CertFile           string // PEM file with the client certificate
+	KeyFile            string // PEM file with the client key
+	InsecureSkipVerify bool   // Do not verify the server certificate. For labs only
+	ServerName         string // Server name for SNI and verification, the host of HostVirtualName if empty
+}
+
 type Connection struct {
+	sync.Mutex
 	Timeout         time.Duration
 	BaseUrl         string
 	HostVirtualName string
 	Token           string
+	RefreshToken    string
+	TokenExpiry     time.Time // Zero if the server did not report the expiry
+	Tls             TlsOptions
+	Proxy           string            // Proxy URL, HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment if empty
+	Headers         map[string]string // Headers added to all requests
+	client          *http.Client
+	clientLock      sync.Mutex
+	username        string
+	password        string
+}
+
+type tokenResponse struct {
+	AccessToken  string  `json:"access_token"`
+	RefreshToken string  `json:"refresh_token"`
+	ExpiresIn    float64 `json:"expires_in"`
+}
+
+// NewTlsConfig creates TLS configuration from the options
+func NewTlsConfig(options TlsOptions) (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: options.InsecureSkipVerify, ServerName: options.ServerName}
+
+	if options.CaFile != "" {
+		caData, err := os.ReadFile(options.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in %s", options.CaFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if options.CertFile != "" || options.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
+
+// httpClient returns the client reused by all requests of the connection
+func (c *Connection) httpClient() (*http.Client, error) {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	tlsOptions := c.Tls
+	if tlsOptions.ServerName == "" && c.HostVirtualName != "" {
+		tlsOptions.ServerName = hostWithoutPort(c.HostVirtualName)
+	}
+
+	tlsConfig, err := NewTlsConfig(tlsOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	if c.Proxy != "" {
+		proxyUrl, err := url.Parse(c.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("proxy '%s': %w", c.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxyUrl)
+	}
+
+	c.client = &http.Client{Timeout: c.Timeout, Transport: transport}
+	return c.client, nil
 }
 
 // Logon to WEB API server. Return token and error
 func (c *Connection) Logon(username string, password string) (string, error, float64) {
+	c.Lock()
+	defer c.Unlock()
 
-	api := http.Client{Timeout: c.Timeout}
-	requestUrl := c.BaseUrl + "/api/token"
+	c.username = username
+	c.password = password
 
 	credentials := url.Values{}
 	credentials.Set("username", username)
 	credentials.Set("password", password)
 
-	req, err := http.NewRequest(http.MethodPost, requestUrl, strings.NewReader(credentials.Encode()))
+	start := time.Now()
+	err := c.requestToken(ApiToken, credentials)
+	responseTime := time.Since(start).Seconds()
+
 	if err != nil {
-		return "", err, -1
+		return "", fmt.Errorf("logon: %w", err), responseTime
 	}
 
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.Token, nil, responseTime
+}
 
-	if c.HostVirtualName != "" {
-		req.Header.Set("Host", c.HostVirtualName)
+// Refresh the access token with the refresh token. Logon again if there is no refresh token or refresh failed
+func (c *Connection) Refresh() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.renewToken()
+}
+
+// Logout from WEB API server and forget the tokens
+func (c *Connection) Logout() error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.Token == "" {
+		return nil
 	}
 
-	start := time.Now()
-	resp, err := api.Do(req)
-	responseTime := time.Since(start).Seconds()
+	// The tokens are forgotten after the logout request has been sent with them
+	defer func() {
+		c.Token, c.RefreshToken, c.TokenExpiry = "", "", time.Time{}
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, c.BaseUrl+ApiLogout, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req, c.Token)
+	if err != nil {
+		return err
+	}
+
+	_ = resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return errors.New(fmt.Sprintf("logout: invalid status (%s) (%d)", resp.Status, resp.StatusCode))
+	}
+	return nil
+}
+
+// IsTokenValid returns true if the token exists and will not expire soon
+func (c *Connection) IsTokenValid() bool {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.isTokenValid()
+}
+
+func (c *Connection) isTokenValid() bool {
+	return c.Token != "" && (c.TokenExpiry.IsZero() || time.Now().Add(TokenExpirySkew).Before(c.TokenExpiry))
+}
+
+// renewToken must be called under the connection lock
+func (c *Connection) renewToken() error {
+	if c.RefreshToken != "" {
+		values := url.Values{}
+		values.Set("grant_type", "refresh_token")
+		values.Set("refresh_token", c.RefreshToken)
+
+		if err := c.requestToken(ApiTokenRefresh, values); err == nil {
+			return nil
+		}
+	}
+
+	if c.username == "" {
+		return ErrUnauthorized
+	}
+
+	credentials := url.Values{}
+	credentials.Set("username", c.username)
+	credentials.Set("password", c.password)
+
+	return c.requestToken(ApiToken, credentials)
+}
 
+func (c *Connection) requestToken(path string, values url.Values) error {
+	req, err := http.NewRequest(http.MethodPost, c.BaseUrl+path, strings.NewReader(values.Encode()))
 	if err != nil {
-		return "", err, responseTime
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.do(req, "")
+	if err != nil {
+		return err
 	}
 
 	if resp.StatusCode != 200 {
 		_ = resp.Body.Close()
-		return "", errors.New(fmt.Sprintf("logon: invalid status (%s) (%d)", resp.Status, resp.StatusCode)), responseTime
+		return errors.New(fmt.Sprintf("invalid status (%s) (%d)", resp.Status, resp.StatusCode))
 	}
 
-	var resultJson map[string]interface{}
+	var result tokenResponse
 
-	err = json.NewDecoder(resp.Body).Decode(&resultJson)
+	err = json.NewDecoder(resp.Body).Decode(&result)
 	_ = resp.Body.Close()
 
 	if err != nil {
-		return "", err, responseTime
+		return err
 	}
 
-	if resultJson["access_token"] == nil {
-		return "", errors.New("access token is empty"), responseTime
+	if result.AccessToken == "" {
+		return errors.New("access token is empty")
 	}
 
-	c.Token = resultJson["access_token"].(string)
-	return c.Token, nil, responseTime
+	c.Token = result.AccessToken
+
+	if result.RefreshToken != "" {
+		c.RefreshToken = result.RefreshToken
+	}
+
+	if result.ExpiresIn > 0 {
+		c.TokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn * float64(time.Second)))
+	} else {
+		c.TokenExpiry = TokenExpiryFromJwt(result.AccessToken)
+	}
+
+	return nil
 }
 
-// GetProfile from WEB API server
-func (c *Connection) GetProfile(path string) ([]byte, error) {
-	api := http.Client{Timeout: c.Timeout}
-	requestUrl := c.BaseUrl + path
+// TokenExpiryFromJwt returns the time from the "exp" claim of JWT or zero time if the token is not JWT
+func TokenExpiryFromJwt(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
 
-	req, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return time.Time{}
+	}
+
+	var claims struct {
+		Exp float64 `json:"exp"`
+	}
+
+	if err = json.Unmarshal(payload, &claims); err != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(claims.Exp), 0)
+}
+
+// do the request with the connection client and headers, authorized with the token if it is not empty
+func (c *Connection) do(req *http.Request, token string) (*http.Response, error) {
+	client, err := c.httpClient()
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+c.Token)
+	for key, value := range c.Headers {
+		req.Header.Set(key, value)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
 
+	// http.Client ignores the Host header, the virtual host must be set in the request
 	if c.HostVirtualName != "" {
-		req.Header.Set("Host", c.HostVirtualName)
+		req.Host = c.HostVirtualName
+	}
+
+	return client.Do(req)
+}
+
+func hostWithoutPort(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
 	}
+	return host
+}
 
-	resp, err := api.Do(req)
+// GetProfile from WEB API server. The token is renewed before the expiry and after 401 status
+func (c *Connection) GetProfile(path string) ([]byte, error) {
+	data, _, err := c.Get(context.Background(), path, nil)
+	return data, err
+}
+
+// Get the resource from WEB API server with the context. The token is renewed before the expiry and after 401 status.
+// The gzip-encoded body is decoded. 304 status is returned as ErrNotModified, other non-2xx status as *ApiError.
+// The connection is locked only to read and renew the token, so the requests run in parallel
+func (c *Connection) Get(ctx context.Context, path string, header http.Header) ([]byte, http.Header, error) {
+	requestUrl := c.BaseUrl + path
+
+	token, err := c.validToken("")
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("get '%s': %w", requestUrl, err)
 	}
 
-	if resp != nil {
-		result, err := io.ReadAll(resp.Body)
+	requestId := RequestIdFromContext(ctx)
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestUrl, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		for key, values := range header {
+			req.Header[key] = values
+		}
+
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set(RequestIdHeader, requestId)
+
+		resp, err := c.do(req, token)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		result, err := readBody(resp)
 		_ = resp.Body.Close()
 
-		if resp.StatusCode != 200 {
-			return nil, errors.New(fmt.Sprintf("get profile '%s': status (%s) (%d)", requestUrl, resp.Status, resp.StatusCode))
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if token, err = c.validToken(token); err != nil {
+				return nil, resp.Header, fmt.Errorf("get '%s': renew token: %w", requestUrl, err)
+			}
+			continue
+		}
+
+		if resp.StatusCode == http.StatusNotModified {
+			return nil, resp.Header, ErrNotModified
+		}
+
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, resp.Header, newApiError(http.MethodGet, requestUrl, requestId, resp, result)
+		}
+
+		return result, resp.Header, err
+	}
+}
+
+// validToken returns the current token renewing it if it expires soon or it is the rejected one
+func (c *Connection) validToken(rejected string) (string, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.username == "" && rejected != "" && c.Token == rejected {
+		return "", ErrUnauthorized
+	}
+
+	if c.username != "" && (!c.isTokenValid() || (rejected != "" && c.Token == rejected)) {
+		if err := c.renewToken(); err != nil {
+			return "", err
 		}
-		return result, err
 	}
+	return c.Token, nil
+}
+
+// readBody reads the response body and decodes gzip content encoding
+func readBody(resp *http.Response) ([]byte, error) {
+	if !strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		return io.ReadAll(resp.Body)
+	}
+
+	reader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
 
-	return nil, errors.New("unknown error")
+	return io.ReadAll(reader)
 }
@@ -0,0 +1,98 @@
+package webapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetRenewsRejectedToken(t *testing.T) {
+	var logons atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case ApiToken:
+			n := logons.Add(1)
+			_, _ = w.Write([]byte(`{"access_token": "t` + string(rune('0'+n)) + `"}`))
+		case ApiGetEquipment:
+			if r.Header.Get("Authorization") != "Bearer t2" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			_, _ = w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	api := &Connection{BaseUrl: server.URL, Timeout: time.Second}
+	if _, err, _ := api.Logon("user", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _, err := api.Get(context.Background(), ApiGetEquipment, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[]" || logons.Load() != 2 {
+		t.Errorf("data %s after %d logons, expected [] after 2", data, logons.Load())
+	}
+}
+
+func TestGetReportsFailedRenewal(t *testing.T) {
+	var logons atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case ApiToken:
+			if logons.Add(1) > 1 {
+				http.Error(w, "locked", http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"access_token": "t1"}`))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	api := &Connection{BaseUrl: server.URL, Timeout: time.Second}
+	if _, err, _ := api.Logon("user", "password"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := api.Get(context.Background(), ApiGetEquipment, nil)
+	if err == nil || !strings.Contains(err.Error(), "renew token") || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("expected the renewal error with the status 403, got %v", err)
+	}
+}
+
+func TestGetIfModifiedSameProfile(t *testing.T) {
+	profile := `[{"id": 1}]`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(profile))
+	}))
+	defer server.Close()
+
+	client := NewClient(&Connection{BaseUrl: server.URL, Timeout: time.Second}, "")
+
+	_, validators, err := client.GetIfModified(context.Background(), ApiGetEquipment, Validators{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = client.GetIfModified(context.Background(), ApiGetEquipment, validators); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected ErrNotModified for the same profile, got %v", err)
+	}
+
+	profile = `[{"id": 2}]`
+	data, _, err := client.GetIfModified(context.Background(), ApiGetEquipment, validators)
+	if err != nil || string(data) != profile {
+		t.Fatalf("expected the modified profile, got %s, %v", data, err)
+	}
+}
@@ -0,0 +1,143 @@
+package zmq_bus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	zmq "github.com/pebbe/zmq4"
+	"time"
+)
+
+const DefaultReconnectBackoffMin = 100 * time.Millisecond
+const DefaultReconnectBackoffMax = 30 * time.Second
+
+// BusState of a socket reported to the state handler
+type BusState int
+
+const (
+	BusStateConnected BusState = iota
+	BusStateReconnecting
+	BusStateFailed
+)
+
+func (state BusState) String() string {
+	switch state {
+	case BusStateConnected:
+		return "connected"
+	case BusStateReconnecting:
+		return "reconnecting"
+	case BusStateFailed:
+		return "failed"
+	}
+	return fmt.Sprintf("unknown (%d)", int(state))
+}
+
+// EndpointStruct describes a socket of the bus
+type EndpointStruct struct {
+	Endpoint     string
+	IsSubscriber bool
+	IsBind       bool
+	IsTopicFrame bool     // The first frame of the messages is the topic
+	Topics       []string // Subscribed topic prefixes, all messages if empty
+}
+
+// SetStateHandler sets the callback for the state changes of the sockets. The handler is called
+// from the receive loop or from Send and must not block
+func (s *ZmqBus) SetStateHandler(handler func(endpoint EndpointStruct, state BusState, err error)) {
+	s.stateHandler = handler
+}
+
+func (s *ZmqBus) notifyState(endpoint EndpointStruct, state BusState, err error) {
+	if s.stateHandler != nil {
+		s.stateHandler(endpoint, state, err)
+	}
+}
+
+// isTerminated is true if the context is terminated or the bus is closed, the socket can not be recreated
+func isTerminated(err error) bool {
+	return errors.Is(err, ErrClosed) || zmq.AsErrno(err) == zmq.ETERM
+}
+
+func (s *ZmqBus) reconnectSubscriber(ctx context.Context, handlerIdx int, cause error) error {
+	endpoint := s.subEndpoint[handlerIdx]
+
+	return s.reconnect(ctx, endpoint, cause, func() error {
+		s.Lock()
+		defer s.Unlock()
+
+		if s.socketSub[handlerIdx] == nil {
+			return ErrClosed
+		}
+
+		_ = s.socketSub[handlerIdx].SetLinger(0)
+		_ = s.socketSub[handlerIdx].Close()
+
+		socket, err := s.newSubscriberSocket(endpoint)
+		if err != nil {
+			// Keep the closed socket until it is recreated, nil means the bus is closed
+			return err
+		}
+
+		s.socketSub[handlerIdx] = socket
+		return nil
+	})
+}
+
+func (s *ZmqBus) reconnectPublisher(ctx context.Context, publisherIdx int, cause error) error {
+	endpoint := s.pubEndpoint[publisherIdx]
+
+	return s.reconnect(ctx, endpoint, cause, func() error {
+		s.Lock()
+		defer s.Unlock()
+
+		if s.socketPub[publisherIdx] == nil {
+			return ErrClosed
+		}
+
+		_ = s.socketPub[publisherIdx].SetLinger(0)
+		_ = s.socketPub[publisherIdx].Close()
+
+		socket, err := s.newPublisherSocket(endpoint)
+		if err != nil {
+			return err
+		}
+
+		s.socketPub[publisherIdx] = socket
+		return nil
+	})
+}
+
+// reconnect recreates the socket with exponential backoff until it succeeds, the retry budget is spent,
+// the bus is closed or the context is cancelled
+func (s *ZmqBus) reconnect(ctx context.Context, endpoint EndpointStruct, cause error, recreate func() error) error {
+	s.notifyState(endpoint, BusStateReconnecting, cause)
+
+	backoff := s.ReconnectBackoffMin
+
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+
+		err := recreate()
+		if err == nil {
+			s.notifyState(endpoint, BusStateConnected, nil)
+			return nil
+		}
+
+		if isTerminated(err) || (s.ReconnectRetries > 0 && attempt >= s.ReconnectRetries) {
+			s.notifyState(endpoint, BusStateFailed, err)
+			return errors.New(fmt.Sprintf("zmq: failed to recreate socket [%s] after %d attempts: %v (%d)",
+				endpoint.Endpoint, attempt, err, zmq.AsErrno(err)))
+		}
+
+		backoff *= 2
+		if backoff > s.ReconnectBackoffMax {
+			backoff = s.ReconnectBackoffMax
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package zmq_bus
+
+// AddSubscriberWithTopics connects the subscriber receiving only the messages with the topic prefixes.
+// The publisher must send the topic in the first frame of the message
+func (s *ZmqBus) AddSubscriberWithTopics(endpoint string, topics []string) (int, error) {
+	return s.addSubscriber(EndpointStruct{
+		Endpoint:     endpoint,
+		IsSubscriber: true,
+		IsTopicFrame: true,
+		Topics:       topics,
+	})
+}
+
+// SetSubscriberTopics replaces the topics of the subscriber. The subscriptions are changed by the receive loop
+// because the socket must not be used from other goroutines
+func (s *ZmqBus) SetSubscriberTopics(handlerIdx int, topics []string) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.pendingTopics[handlerIdx] = topics
+}
+
+// applyPendingTopics subscribes to the new topics and unsubscribes from the removed ones
+func (s *ZmqBus) applyPendingTopics(handlerIdxArray []int) {
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.pendingTopics) == 0 {
+		return
+	}
+
+	for _, handlerIdx := range handlerIdxArray {
+		topics, exists := s.pendingTopics[handlerIdx]
+		if !exists {
+			continue
+		}
+		delete(s.pendingTopics, handlerIdx)
+
+		socket := s.socketSub[handlerIdx]
+		endpoint := &s.subEndpoint[handlerIdx]
+
+		if socket != nil {
+			oldTopics := subscriptions(endpoint.Topics)
+			newTopics := subscriptions(topics)
+
+			for topic := range oldTopics {
+				if !newTopics[topic] {
+					_ = socket.SetUnsubscribe(topic)
+				}
+			}
+			for topic := range newTopics {
+				if !oldTopics[topic] {
+					_ = socket.SetSubscribe(topic)
+				}
+			}
+		}
+
+		// A recreated socket subscribes to the new topics
+		endpoint.Topics = topics
+	}
+}
+
+// subscriptions of the socket for the topics, an empty topic subscribes to all messages
+func subscriptions(topics []string) map[string]bool {
+	if len(topics) == 0 {
+		return map[string]bool{"": true}
+	}
+
+	subscribed := make(map[string]bool, len(topics))
+	for _, topic := range topics {
+		subscribed[topic] = true
+	}
+	return subscribed
+}
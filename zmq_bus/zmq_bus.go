@@ -5,23 +5,56 @@ import (
 	"errors"
 	"fmt"
 	zmq "github.com/pebbe/zmq4"
-	"golang.org/x/sync/errgroup"
 	"sync"
+	"syscall"
 	"time"
 )
 
 const RtdbInterrogationCommand = "{\"dest\": \"rtdb\", \"cmd\": \"gi\"}"
 
+// PollTimeout limits the reaction time of the receive loop to the context cancellation
+const PollTimeout = 250 * time.Millisecond
+
+// MaxDrainMessages limits the messages received from one socket per poll, so a busy subscriber
+// does not starve the others and the context cancellation
+const MaxDrainMessages = 1000
+
+const DefaultGiTimeout = 2 * time.Second
+const DefaultGiRetries = 5
+
+// DefaultLinger is the time to deliver the pending messages after the publishers are closed
+const DefaultLinger = time.Second
+
+// DefaultPublisherReconnectTimeout limits the time Send waits for a failed publisher to be recreated
+const DefaultPublisherReconnectTimeout = 5 * time.Second
+
+var ErrInterrogationTimeout = errors.New("zmq: general interrogation was not confirmed")
+var ErrClosed = errors.New("zmq: bus is closed")
+
 type ZmqBus struct {
 	sync.Mutex
-	ctx        *zmq.Context
-	socketSub  []*zmq.Socket
-	socketPub  []*zmq.Socket
-	wg         sync.WaitGroup
-	handler    []func([]string)
-	subIdx     int
-	pubIdx     int
-	handlerIdx int
+	ctx       *zmq.Context
+	socketSub []*zmq.Socket
+	socketPub []*zmq.Socket
+	wg        sync.WaitGroup
+	handler   []func([]string)
+	subIdx    int
+	pubIdx    int
+	GiTimeout time.Duration // Timeout of one GI attempt
+	GiRetries int
+	Linger    time.Duration // Time to deliver the pending messages on Close
+
+	subEndpoint         []EndpointStruct
+	pendingTopics       map[int][]string
+	pubEndpoint         []EndpointStruct
+	stateHandler        func(EndpointStruct, BusState, error)
+	ReconnectRetries    int // Attempts to recreate a failed socket before the error is returned, 0 - unlimited
+	ReconnectBackoffMin time.Duration
+	ReconnectBackoffMax time.Duration
+
+	PublisherReconnectTimeout time.Duration // Time Send waits for a failed publisher to be recreated, 0 - until Close
+	sendCtx                   context.Context
+	cancelSend                context.CancelFunc
 }
 
 func New(numSubHandlers int, numPublishers int) (*ZmqBus, error) {
@@ -30,92 +63,105 @@ func New(numSubHandlers int, numPublishers int) (*ZmqBus, error) {
 		return nil, err
 	}
 
+	sendCtx, cancelSend := context.WithCancel(context.Background())
+
 	return &ZmqBus{
-		ctx:        ctx,
-		socketSub:  make([]*zmq.Socket, numSubHandlers),
-		socketPub:  make([]*zmq.Socket, numPublishers),
-		subIdx:     0,
-		pubIdx:     0,
-		handler:    make([]func([]string), numSubHandlers),
-		handlerIdx: 0}, err
+		ctx:       ctx,
+		socketSub: make([]*zmq.Socket, numSubHandlers),
+		socketPub: make([]*zmq.Socket, numPublishers),
+		subIdx:    0,
+		pubIdx:    0,
+		handler:   make([]func([]string), numSubHandlers),
+		GiTimeout: DefaultGiTimeout,
+		GiRetries: DefaultGiRetries,
+		Linger:    DefaultLinger,
+
+		subEndpoint:         make([]EndpointStruct, numSubHandlers),
+		pendingTopics:       make(map[int][]string),
+		pubEndpoint:         make([]EndpointStruct, numPublishers),
+		ReconnectBackoffMin: DefaultReconnectBackoffMin,
+		ReconnectBackoffMax: DefaultReconnectBackoffMax,
+
+		PublisherReconnectTimeout: DefaultPublisherReconnectTimeout,
+		sendCtx:                   sendCtx,
+		cancelSend:                cancelSend}, err
 }
 
 func (s *ZmqBus) AddSubscriber(endpoint string) (int, error) {
-	socket, err := s.ctx.NewSocket(zmq.SUB)
-	if err != nil {
-		return -1, err
-	}
+	return s.addSubscriber(EndpointStruct{Endpoint: endpoint, IsSubscriber: true})
+}
 
-	err = socket.SetSubscribe("")
-	if err != nil {
-		return -1, err
-	}
+func (s *ZmqBus) AddBindSubscriber(endpoint string) (int, error) {
+	return s.addSubscriber(EndpointStruct{Endpoint: endpoint, IsSubscriber: true, IsBind: true})
+}
 
-	err = socket.Connect(endpoint)
+func (s *ZmqBus) addSubscriber(endpoint EndpointStruct) (int, error) {
+	socket, err := s.newSubscriberSocket(endpoint)
 	if err != nil {
 		return -1, err
 	}
 
 	s.socketSub[s.subIdx] = socket
+	s.subEndpoint[s.subIdx] = endpoint
 	s.subIdx += 1
 
 	return s.subIdx - 1, nil
 }
 
-func (s *ZmqBus) AddBindSubscriber(endpoint string) (int, error) {
+func (s *ZmqBus) newSubscriberSocket(endpoint EndpointStruct) (*zmq.Socket, error) {
 	socket, err := s.ctx.NewSocket(zmq.SUB)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
-	err = socket.SetSubscribe("")
-	if err != nil {
-		return -1, err
+	for topic := range subscriptions(endpoint.Topics) {
+		if err = socket.SetSubscribe(topic); err != nil {
+			break
+		}
 	}
 
-	err = socket.Bind(endpoint)
-	if err != nil {
-		return -1, err
+	if err == nil {
+		if endpoint.IsBind {
+			err = socket.Bind(endpoint.Endpoint)
+		} else {
+			err = socket.Connect(endpoint.Endpoint)
+		}
 	}
 
-	s.socketSub[s.subIdx] = socket
-	s.subIdx += 1
+	if err != nil {
+		_ = socket.SetLinger(0)
+		_ = socket.Close()
+		return nil, err
+	}
 
-	return s.subIdx - 1, nil
+	return socket, nil
 }
 
 func (s *ZmqBus) AddPublisher(endpoint string) (int, error) {
-	socket, err := s.ctx.NewSocket(zmq.PUB)
-	if err != nil {
-		return -1, err
-	}
-
-	err = socket.Connect(endpoint)
+	socket, err := s.newPublisherSocket(EndpointStruct{Endpoint: endpoint})
 	if err != nil {
 		return -1, err
 	}
 
 	s.socketPub[s.pubIdx] = socket
+	s.pubEndpoint[s.pubIdx] = EndpointStruct{Endpoint: endpoint}
 	s.pubIdx += 1
 	return s.pubIdx - 1, nil
 }
 
-func (s *ZmqBus) receiveHandler() error {
-	s.Lock()
-	handlerIdx := s.handlerIdx
-	s.handlerIdx += 1
-	s.Unlock()
+func (s *ZmqBus) newPublisherSocket(endpoint EndpointStruct) (*zmq.Socket, error) {
+	socket, err := s.ctx.NewSocket(zmq.PUB)
+	if err != nil {
+		return nil, err
+	}
 
-	for {
-		msg, err := s.socketSub[handlerIdx].RecvMessage(zmq.DONTWAIT)
-		if err == nil {
-			s.handler[handlerIdx](msg)
-		} else if zmq.AsErrno(err) == 35 || zmq.AsErrno(err) == 11 {
-			time.Sleep(10 * time.Millisecond)
-		} else {
-			return errors.New(fmt.Sprintf("zmq: %v (%d)", err, zmq.AsErrno(err)))
-		}
+	if err = socket.Connect(endpoint.Endpoint); err != nil {
+		_ = socket.SetLinger(0)
+		_ = socket.Close()
+		return nil, err
 	}
+
+	return socket, nil
 }
 
 func (s *ZmqBus) SetReceiveHandler(handlerIdx int, handler func([]string)) {
@@ -124,22 +170,123 @@ func (s *ZmqBus) SetReceiveHandler(handlerIdx int, handler func([]string)) {
 	}
 }
 
+// ReceiveLoopWithHandler receives messages from one subscriber until an error
 func (s *ZmqBus) ReceiveLoopWithHandler(handlerIdx int) error {
+	return s.receiveLoop(context.Background(), []int{handlerIdx})
+}
+
+// ReceiveLoopWithHandlerContext receives messages from one subscriber until the context is cancelled or an error
+func (s *ZmqBus) ReceiveLoopWithHandlerContext(ctx context.Context, handlerIdx int) error {
+	return s.receiveLoop(ctx, []int{handlerIdx})
+}
+
+// receiveLoop waits for messages on the subscribers with zmq.Poller and calls their handlers.
+// The poll timeout only limits the reaction time to the context cancellation, data is handled as soon as it arrives.
+// A failed subscriber is recreated, the error is returned if the socket can not be recreated or the bus is closed
+func (s *ZmqBus) receiveLoop(ctx context.Context, handlerIdxArray []int) error {
+	poller, handlerIdxFromSocket := s.newPoller(handlerIdxArray)
+
+	if len(handlerIdxFromSocket) == 0 {
+		return errors.New("zmq: no subscribers")
+	}
+
 	for {
-		msg, err := s.socketSub[handlerIdx].RecvMessage(zmq.DONTWAIT)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		s.applyPendingTopics(handlerIdxArray)
+
+		polled, err := poller.Poll(PollTimeout)
+		if err != nil {
+			if zmq.AsErrno(err) == zmq.Errno(syscall.EINTR) {
+				continue
+			}
+			if isTerminated(err) {
+				return errorWithErrno(err)
+			}
+			for _, handlerIdx := range handlerIdxArray {
+				if err = s.reconnectSubscriber(ctx, handlerIdx, err); err != nil {
+					return err
+				}
+			}
+			poller, handlerIdxFromSocket = s.newPoller(handlerIdxArray)
+			continue
+		}
+
+		isReconnected := false
+
+		for _, item := range polled {
+			handlerIdx := handlerIdxFromSocket[item.Socket]
+			if err = s.drain(item.Socket, s.subEndpoint[handlerIdx].IsTopicFrame, s.handler[handlerIdx]); err != nil {
+				if isTerminated(err) {
+					return errorWithErrno(err)
+				}
+				if err = s.reconnectSubscriber(ctx, handlerIdx, err); err != nil {
+					return err
+				}
+				isReconnected = true
+			}
+		}
+
+		if isReconnected {
+			poller, handlerIdxFromSocket = s.newPoller(handlerIdxArray)
+		}
+	}
+}
+
+func (s *ZmqBus) newPoller(handlerIdxArray []int) (*zmq.Poller, map[*zmq.Socket]int) {
+	s.Lock()
+	defer s.Unlock()
+
+	poller := zmq.NewPoller()
+	handlerIdxFromSocket := make(map[*zmq.Socket]int, len(handlerIdxArray))
+
+	for _, handlerIdx := range handlerIdxArray {
+		socket := s.socketSub[handlerIdx]
+		if socket == nil {
+			continue
+		}
+		poller.Add(socket, zmq.POLLIN)
+		handlerIdxFromSocket[socket] = handlerIdx
+	}
+
+	return poller, handlerIdxFromSocket
+}
+
+// drain receives up to MaxDrainMessages queued messages from the socket without blocking,
+// the rest is received after the next poll. The topic frame is not passed to the handler
+func (s *ZmqBus) drain(socket *zmq.Socket, isTopicFrame bool, handler func([]string)) error {
+	for count := 0; count < MaxDrainMessages; count++ {
+		msg, err := socket.RecvMessage(zmq.DONTWAIT)
 		if err == nil {
-			s.handler[handlerIdx](msg)
-		} else if zmq.AsErrno(err) == 35 || zmq.AsErrno(err) == 11 {
-			time.Sleep(10 * time.Millisecond)
+			if isTopicFrame && len(msg) > 0 {
+				msg = msg[1:]
+			}
+			if handler != nil {
+				handler(msg)
+			}
+		} else if isAgain(err) {
+			return nil
 		} else {
-			return errors.New(fmt.Sprintf("zmq: %v (%d)", err, zmq.AsErrno(err)))
+			return err
 		}
 	}
+	return nil
 }
 
+func errorWithErrno(err error) error {
+	return errors.New(fmt.Sprintf("zmq: %v (%d)", err, zmq.AsErrno(err)))
+}
+
+// SendInterrogationCommandToRtdb publishes GI command to the RTDB input bus.
+// XPUB socket receives the subscription of RTDB after the connection is established,
+// so the command is sent as soon as RTDB is ready to receive it instead of waiting for a fixed delay
 func (s *ZmqBus) SendInterrogationCommandToRtdb(endpoint string) (int, error) {
 
-	client, err := s.ctx.NewSocket(zmq.PUB)
+	client, err := s.ctx.NewSocket(zmq.XPUB)
 	if err != nil {
 		return 0, err
 	}
@@ -148,33 +295,193 @@ func (s *ZmqBus) SendInterrogationCommandToRtdb(endpoint string) (int, error) {
 		_ = client.Close()
 	}(client)
 
+	// Keep the command in the queue on close until it is delivered
+	if err = client.SetLinger(s.GiTimeout); err != nil {
+		return 0, err
+	}
+
+	if err = client.SetRcvtimeo(s.GiTimeout); err != nil {
+		return 0, err
+	}
+
 	err = client.Connect(endpoint)
 	if err != nil {
 		return 0, err
 	}
 
-	// A delay is required before data is sent to zmq
-	time.Sleep(4 * time.Second)
+	for attempt := 0; attempt <= s.GiRetries; attempt++ {
+		msg, err := client.RecvBytes(0)
+		if err != nil {
+			if isAgain(err) {
+				continue
+			}
+			return 0, err
+		}
+
+		// Subscription message: 0x01 and the topic
+		if len(msg) > 0 && msg[0] == 1 {
+			return client.Send(RtdbInterrogationCommand, 0)
+		}
+	}
+
+	return 0, ErrInterrogationTimeout
+}
+
+// RequestInterrogationFromRtdb sends GI command to the RTDB REQ/REP endpoint and returns the reply of RTDB.
+// The request is retried with a new socket after the timeout (Lazy Pirate pattern)
+func (s *ZmqBus) RequestInterrogationFromRtdb(endpoint string) (string, error) {
+	for attempt := 0; attempt <= s.GiRetries; attempt++ {
+		reply, err := s.requestOnce(endpoint, RtdbInterrogationCommand)
+		if err == nil {
+			return reply, nil
+		}
+		if !isAgain(err) {
+			return "", err
+		}
+	}
+	return "", ErrInterrogationTimeout
+}
+
+func (s *ZmqBus) requestOnce(endpoint string, request string) (string, error) {
+	client, err := s.ctx.NewSocket(zmq.REQ)
+	if err != nil {
+		return "", err
+	}
+
+	defer func(client *zmq.Socket) {
+		_ = client.Close()
+	}(client)
+
+	if err = client.SetLinger(0); err != nil {
+		return "", err
+	}
+
+	if err = client.SetRcvtimeo(s.GiTimeout); err != nil {
+		return "", err
+	}
+
+	if err = client.SetSndtimeo(s.GiTimeout); err != nil {
+		return "", err
+	}
+
+	if err = client.Connect(endpoint); err != nil {
+		return "", err
+	}
+
+	if _, err = client.Send(request, 0); err != nil {
+		return "", err
+	}
+
+	return client.Recv(0)
+}
 
-	return client.Send(RtdbInterrogationCommand, 0)
+func isAgain(err error) bool {
+	return zmq.AsErrno(err) == 35 || zmq.AsErrno(err) == 11
 }
 
+// Send publishes data. A failed publisher is recreated and the data is sent again once.
+// Send waits for the publisher no longer than PublisherReconnectTimeout and returns as soon as the bus is closed
 func (s *ZmqBus) Send(publisherIdx int, data []byte) (int, error) {
+	return s.sendFrames(publisherIdx, string(data))
+}
+
+// SendWithTopic publishes data as the multipart message [topic, data]
+func (s *ZmqBus) SendWithTopic(publisherIdx int, topic string, data []byte) (int, error) {
+	return s.sendFrames(publisherIdx, topic, string(data))
+}
+
+func (s *ZmqBus) sendFrames(publisherIdx int, frames ...string) (int, error) {
+	count, err := s.send(publisherIdx, frames)
+	if err == nil || isAgain(err) || isTerminated(err) {
+		return count, err
+	}
+
+	ctx, cancel := s.sendContext()
+	defer cancel()
+
+	if err = s.reconnectPublisher(ctx, publisherIdx, err); err != nil {
+		return 0, err
+	}
+
+	return s.send(publisherIdx, frames)
+}
+
+func (s *ZmqBus) sendContext() (context.Context, context.CancelFunc) {
+	if s.PublisherReconnectTimeout > 0 {
+		return context.WithTimeout(s.sendCtx, s.PublisherReconnectTimeout)
+	}
+	return context.WithCancel(s.sendCtx)
+}
+
+func (s *ZmqBus) send(publisherIdx int, frames []string) (int, error) {
 	s.Lock()
-	count, err := s.socketPub[publisherIdx].Send(string(data), 0)
-	s.Unlock()
+	defer s.Unlock()
+
+	if s.socketPub[publisherIdx] == nil {
+		return 0, ErrClosed
+	}
 
-	return count, err
+	if len(frames) == 1 {
+		return s.socketPub[publisherIdx].Send(frames[0], 0)
+	}
+
+	parts := make([]interface{}, len(frames))
+	for i, frame := range frames {
+		parts[i] = frame
+	}
+	return s.socketPub[publisherIdx].SendMessage(parts...)
 }
 
+// WaitingLoop receives messages from all subscribers until an error
 func (s *ZmqBus) WaitingLoop() error {
+	return s.WaitingLoopContext(context.Background())
+}
+
+// WaitingLoopContext receives messages from all subscribers in one goroutine until the context is cancelled or an error
+func (s *ZmqBus) WaitingLoopContext(ctx context.Context) error {
+	handlerIdxArray := make([]int, 0, s.subIdx)
+	for i := 0; i < s.subIdx; i++ {
+		handlerIdxArray = append(handlerIdxArray, i)
+	}
+	return s.receiveLoop(ctx, handlerIdxArray)
+}
+
+// Close closes all sockets and terminates the context. The pending messages of the publishers
+// are delivered within Linger. Must be called after the receive loop is stopped.
+// Send waiting for a publisher to be recreated returns immediately
+func (s *ZmqBus) Close() error {
+	s.cancelSend()
+
+	s.Lock()
+	defer s.Unlock()
 
-	g, _ := errgroup.WithContext(context.Background())
+	var errs []error
 
-	for i := 0; i < len(s.handler); i++ {
-		g.Go(func() error {
-			return s.receiveHandler()
-		})
+	for i := 0; i < s.subIdx; i++ {
+		if s.socketSub[i] == nil {
+			continue
+		}
+		_ = s.socketSub[i].SetLinger(0)
+		if err := s.socketSub[i].Close(); err != nil {
+			errs = append(errs, err)
+		}
+		s.socketSub[i] = nil
 	}
-	return g.Wait()
+
+	for i := 0; i < s.pubIdx; i++ {
+		if s.socketPub[i] == nil {
+			continue
+		}
+		_ = s.socketPub[i].SetLinger(s.Linger)
+		if err := s.socketPub[i].Close(); err != nil {
+			errs = append(errs, err)
+		}
+		s.socketPub[i] = nil
+	}
+
+	if err := s.ctx.Term(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
 }